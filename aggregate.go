@@ -0,0 +1,331 @@
+package metrics
+
+import (
+	"context"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sketch is a relative-accuracy histogram: every value v is counted in the
+// bucket i such that gamma^(i-1) < |v| <= gamma^i, so the bucket
+// representative is within the configured relative error of v
+type sketch struct {
+	gamma    float64
+	positive map[int]uint64
+	negative map[int]uint64
+	zeros    uint64
+}
+
+func newSketch(accuracy float64) *sketch {
+	return &sketch{
+		gamma:    (1 + accuracy) / (1 - accuracy),
+		positive: make(map[int]uint64),
+		negative: make(map[int]uint64),
+	}
+}
+
+func (s *sketch) index(v float64) int {
+	return int(math.Ceil(math.Log(v) / math.Log(s.gamma)))
+}
+
+func (s *sketch) value(index int) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+}
+
+func (s *sketch) add(v float64) {
+	switch {
+	case v > 0:
+		s.positive[s.index(v)]++
+	case v < 0:
+		s.negative[s.index(-v)]++
+	default:
+		s.zeros++
+	}
+}
+
+// replay calls observe once per bucket with its representative and count,
+// from the lowest to the highest value
+func (s *sketch) replay(observe func(v float64, count uint64) error) error {
+	var errs []error
+	emit := func(v float64, count uint64) {
+		if count > 0 {
+			errs = append(errs, observe(v, count))
+		}
+	}
+	for _, i := range sortedIndexes(s.negative, true) {
+		emit(-s.value(i), s.negative[i])
+	}
+	emit(0, s.zeros)
+	for _, i := range sortedIndexes(s.positive, false) {
+		emit(s.value(i), s.positive[i])
+	}
+	return joinErrors(errs...)
+}
+
+// reportWeighted sends value observed count times to r in one call when r
+// implements WeightedReporter, once per observation otherwise
+func reportWeighted(r Reporter, kind sampleKind, metric string, tags map[string]string, value float64, count uint64) error {
+	if w, ok := r.(WeightedReporter); ok {
+		if kind == histogramSample {
+			return w.ReportHistogramWeighted(metric, tags, value, count)
+		}
+		return w.ReportSummaryWeighted(metric, tags, value, count)
+	}
+	report := r.ReportSummary
+	if kind == histogramSample {
+		report = r.ReportHistogram
+	}
+	for ; count > 0; count-- {
+		if err := report(metric, tags, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedIndexes(buckets map[int]uint64, reverse bool) []int {
+	indexes := make([]int, 0, len(buckets))
+	for i := range buckets {
+		indexes = append(indexes, i)
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(sort.IntSlice(indexes)))
+	} else {
+		sort.Ints(indexes)
+	}
+	return indexes
+}
+
+type aggregatedSeries struct {
+	metric string
+	tags   map[string]string
+	value  float64
+	sketch *sketch
+}
+
+// AggregatingReporter decorates a Reporter, summing counters, keeping the
+// last gauge values and sketching summaries and histograms per metric and
+// tag set, and sends the aggregates to the wrapped reporter on every flush.
+// Sketches are sent one call per bucket to reporters implementing
+// WeightedReporter, one call per observation to the others
+type AggregatingReporter struct {
+	reporter Reporter
+	interval time.Duration
+	accuracy float64
+
+	mu         sync.Mutex
+	counts     map[string]*aggregatedSeries
+	gauges     map[string]*aggregatedSeries
+	summaries  map[string]*aggregatedSeries
+	histograms map[string]*aggregatedSeries
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// AggregatingOption configures an AggregatingReporter
+type AggregatingOption func(*AggregatingReporter)
+
+// WithFlushInterval sets how often aggregates are flushed, 10s by default
+func WithFlushInterval(interval time.Duration) AggregatingOption {
+	return func(a *AggregatingReporter) {
+		if interval > 0 {
+			a.interval = interval
+		}
+	}
+}
+
+// WithRelativeAccuracy sets the relative error of the summary and histogram
+// sketches, between 0 and 1 exclusive, 0.01 by default
+func WithRelativeAccuracy(accuracy float64) AggregatingOption {
+	return func(a *AggregatingReporter) {
+		if accuracy > 0 && accuracy < 1 {
+			a.accuracy = accuracy
+		}
+	}
+}
+
+// NewAggregatingReporter wraps reporter and starts the flush loop, call
+// Shutdown to send the last aggregates and stop it
+func NewAggregatingReporter(reporter Reporter, opts ...AggregatingOption) *AggregatingReporter {
+	a := &AggregatingReporter{
+		reporter: reporter,
+		interval: 10 * time.Second,
+		accuracy: 0.01,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.reset()
+
+	go a.loop()
+	return a
+}
+
+func (a *AggregatingReporter) reset() {
+	a.counts = make(map[string]*aggregatedSeries)
+	a.gauges = make(map[string]*aggregatedSeries)
+	a.summaries = make(map[string]*aggregatedSeries)
+	a.histograms = make(map[string]*aggregatedSeries)
+}
+
+func (a *AggregatingReporter) loop() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.flush()
+		}
+	}
+}
+
+func (a *AggregatingReporter) series(set map[string]*aggregatedSeries, metric string, tags map[string]string) *aggregatedSeries {
+	key := seriesKey(metric, tags)
+	s, ok := set[key]
+	if !ok {
+		s = &aggregatedSeries{metric: metric, tags: copyTags(tags)}
+		set[key] = s
+	}
+	return s
+}
+
+func (a *AggregatingReporter) ReportCount(metric string, tags map[string]string, count float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.series(a.counts, metric, tags).value += count
+	return nil
+}
+
+func (a *AggregatingReporter) ReportGauge(metric string, tags map[string]string, value float64) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.series(a.gauges, metric, tags).value = value
+	return nil
+}
+
+func (a *AggregatingReporter) ReportSummary(metric string, tags map[string]string, value float64) error {
+	a.observe(a.summaries, metric, tags, value)
+	return nil
+}
+
+func (a *AggregatingReporter) ReportHistogram(metric string, tags map[string]string, value float64) error {
+	a.observe(a.histograms, metric, tags, value)
+	return nil
+}
+
+func (a *AggregatingReporter) observe(set map[string]*aggregatedSeries, metric string, tags map[string]string, value float64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.series(set, metric, tags)
+	if s.sketch == nil {
+		s.sketch = newSketch(a.accuracy)
+	}
+	s.sketch.add(value)
+}
+
+// RegisterCounter declares counter on the wrapped reporter
+func (a *AggregatingReporter) RegisterCounter(counter *config.Counter) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.RegisterCounter(counter) })
+}
+
+// RegisterGauge declares gauge on the wrapped reporter
+func (a *AggregatingReporter) RegisterGauge(gauge *config.Gauge) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.RegisterGauge(gauge) })
+}
+
+// RegisterSummary declares summary on the wrapped reporter
+func (a *AggregatingReporter) RegisterSummary(summary *config.Summary) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.RegisterSummary(summary) })
+}
+
+// RegisterHistogram declares histogram on the wrapped reporter
+func (a *AggregatingReporter) RegisterHistogram(histogram *config.Histogram) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.RegisterHistogram(histogram) })
+}
+
+// Unregister removes metric from the wrapped reporter
+func (a *AggregatingReporter) Unregister(metric string) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.Unregister(metric) })
+}
+
+// Flush sends the current aggregates to the wrapped reporter and starts new
+// ones, flushing the wrapped reporter too when it implements Flusher
+func (a *AggregatingReporter) Flush() error {
+	err := a.flush()
+	if f, ok := a.reporter.(Flusher); ok {
+		err = joinErrors(err, f.Flush())
+	}
+	return err
+}
+
+func (a *AggregatingReporter) flush() error {
+	a.mu.Lock()
+	counts, gauges, summaries, histograms := a.counts, a.gauges, a.summaries, a.histograms
+	a.reset()
+	a.mu.Unlock()
+
+	var errs []error
+	for _, s := range counts {
+		errs = append(errs, a.reporter.ReportCount(s.metric, s.tags, s.value))
+	}
+	for _, s := range gauges {
+		errs = append(errs, a.reporter.ReportGauge(s.metric, s.tags, s.value))
+	}
+	for _, s := range summaries {
+		s := s
+		errs = append(errs, s.sketch.replay(func(v float64, count uint64) error {
+			return reportWeighted(a.reporter, summarySample, s.metric, s.tags, v, count)
+		}))
+	}
+	for _, s := range histograms {
+		s := s
+		errs = append(errs, s.sketch.replay(func(v float64, count uint64) error {
+			return reportWeighted(a.reporter, histogramSample, s.metric, s.tags, v, count)
+		}))
+	}
+	return joinErrors(errs...)
+}
+
+// Shutdown stops the flush loop, flushes the last aggregates and shuts down
+// the wrapped reporter
+func (a *AggregatingReporter) Shutdown(ctx context.Context) error {
+	a.once.Do(func() {
+		close(a.stop)
+	})
+	select {
+	case <-a.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return joinErrors(a.flush(), shutdownReporter(ctx, a.reporter))
+}
+
+// seriesKey identifies a metric and tag set regardless of the map order
+func seriesKey(metric string, tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(metric)
+	for _, k := range keys {
+		b.WriteByte(0)
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(tags[k])
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"github.com/golang/mock/gomock"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestAggregatingReporter(t *testing.T) (*AggregatingReporter, *mocks.MockReporter) {
+	ctrl := gomock.NewController(t)
+	t.Cleanup(ctrl.Finish)
+	mockMetricsReporter := mocks.NewMockReporter(ctrl)
+	a := NewAggregatingReporter(mockMetricsReporter, WithFlushInterval(time.Hour))
+	t.Cleanup(func() {
+		a.Shutdown(context.Background())
+	})
+	return a, mockMetricsReporter
+}
+
+func TestAggregatingReporterCounts(t *testing.T) {
+	a, mockMetricsReporter := newTestAggregatingReporter(t)
+
+	assert.NoError(t, a.ReportCount(ExceededRateLimiting, map[string]string{"a": "1", "b": "2"}, 1))
+	assert.NoError(t, a.ReportCount(ExceededRateLimiting, map[string]string{"b": "2", "a": "1"}, 2))
+	assert.NoError(t, a.ReportCount(ExceededRateLimiting, map[string]string{"a": "1", "b": "2"}, 3))
+	assert.NoError(t, a.ReportCount(ExceededRateLimiting, map[string]string{"a": "other"}, 1))
+
+	mockMetricsReporter.EXPECT().ReportCount(ExceededRateLimiting, map[string]string{"a": "1", "b": "2"}, float64(6))
+	mockMetricsReporter.EXPECT().ReportCount(ExceededRateLimiting, map[string]string{"a": "other"}, float64(1))
+	assert.NoError(t, a.Flush())
+
+	// aggregates restart from zero after a flush
+	assert.NoError(t, a.ReportCount(ExceededRateLimiting, map[string]string{"a": "other"}, 4))
+	mockMetricsReporter.EXPECT().ReportCount(ExceededRateLimiting, map[string]string{"a": "other"}, float64(4))
+	assert.NoError(t, a.Flush())
+}
+
+func TestAggregatingReporterGauges(t *testing.T) {
+	a, mockMetricsReporter := newTestAggregatingReporter(t)
+
+	assert.NoError(t, a.ReportGauge(ConnectedClients, map[string]string{}, 10))
+	assert.NoError(t, a.ReportGauge(ConnectedClients, map[string]string{}, 5))
+	assert.NoError(t, a.ReportGauge(ConnectedClients, map[string]string{}, 7))
+
+	mockMetricsReporter.EXPECT().ReportGauge(ConnectedClients, map[string]string{}, float64(7))
+	assert.NoError(t, a.Flush())
+}
+
+func TestAggregatingReporterSummaries(t *testing.T) {
+	a, mockMetricsReporter := newTestAggregatingReporter(t)
+
+	for _, v := range []float64{250, 100, 100} {
+		assert.NoError(t, a.ReportSummary(ResponseTime, map[string]string{"route": "room.join"}, v))
+	}
+
+	var values []float64
+	mockMetricsReporter.EXPECT().ReportSummary(ResponseTime, map[string]string{"route": "room.join"}, gomock.Any()).Do(
+		func(metric string, tags map[string]string, value float64) {
+			values = append(values, value)
+		},
+	).Times(3)
+	assert.NoError(t, a.Flush())
+
+	assert.Len(t, values, 3)
+	assert.InEpsilon(t, 100, values[0], 0.01)
+	assert.InEpsilon(t, 100, values[1], 0.01)
+	assert.InEpsilon(t, 250, values[2], 0.01)
+}
+
+func TestAggregatingReporterWeighted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := mocks.NewMockClient(ctrl)
+	statsd, err := NewStatsdReporter(config.Metrics{GoTechBookFrameworkMetricsStatsdRate: 1}, "game", client)
+	if !assert.NoError(t, err) {
+		return
+	}
+	a := NewAggregatingReporter(statsd, WithFlushInterval(0), WithRelativeAccuracy(1))
+	defer a.Shutdown(context.Background())
+	assert.Equal(t, 10*time.Second, a.interval)
+	assert.Equal(t, 0.01, a.accuracy)
+
+	for i := 0; i < 1000; i++ {
+		assert.NoError(t, a.ReportHistogram("room_size", map[string]string{}, 4))
+	}
+	client.EXPECT().Histogram("room_size", gomock.Any(), gomock.Any(), 0.001)
+	assert.NoError(t, a.Flush())
+}
+
+func TestSketch(t *testing.T) {
+	t.Run("relative-accuracy", func(t *testing.T) {
+		s := newSketch(0.01)
+		for _, v := range []float64{0.001, 1, 3.7, 42, 1e9} {
+			assert.InEpsilon(t, v, s.value(s.index(v)), 0.01, "value %v", v)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		s := newSketch(0.01)
+		for _, v := range []float64{1, -3, 2, 0, 2, 100, 2} {
+			s.add(v)
+		}
+		var values []float64
+		var counts []uint64
+		assert.NoError(t, s.replay(func(v float64, count uint64) error {
+			values = append(values, v)
+			counts = append(counts, count)
+			return nil
+		}))
+		assert.Equal(t, []uint64{1, 1, 1, 3, 1}, counts)
+		assert.InEpsilon(t, -3, values[0], 0.01)
+		assert.Equal(t, float64(0), values[1])
+		assert.InEpsilon(t, 2, values[3], 0.01)
+		assert.InEpsilon(t, 100, values[4], 0.01)
+	})
+}
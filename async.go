@@ -0,0 +1,297 @@
+package metrics
+
+import (
+	"context"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"sync"
+	"sync/atomic"
+)
+
+// DropPolicy tells AsyncReporter what to do with a sample when its queue is full
+type DropPolicy int
+
+const (
+	// DropOldest evicts the oldest queued sample to make room for the new one
+	DropOldest DropPolicy = iota
+	// DropNewest discards the new sample and returns ErrSampleDropped
+	DropNewest
+	// Block waits for a worker to free a slot
+	Block
+)
+
+type sampleKind int
+
+const (
+	countSample sampleKind = iota
+	summarySample
+	histogramSample
+	gaugeSample
+)
+
+type asyncSample struct {
+	kind   sampleKind
+	metric string
+	tags   map[string]string
+	value  float64
+}
+
+// AsyncReporter decorates a Reporter so that reports are queued in a bounded
+// ring buffer and sent by background workers, off the caller goroutine
+type AsyncReporter struct {
+	reporter Reporter
+	policy   DropPolicy
+	workers  int
+
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	idle     *sync.Cond
+	queue    []asyncSample
+	head     int
+	size     int
+	inFlight int
+	closed   bool
+	wg       sync.WaitGroup
+
+	dropped uint64
+	failed  uint64
+}
+
+// AsyncOption configures an AsyncReporter
+type AsyncOption func(*AsyncReporter)
+
+// WithQueueSize sets the ring buffer capacity, 1024 by default
+func WithQueueSize(size int) AsyncOption {
+	return func(a *AsyncReporter) {
+		if size > 0 {
+			a.queue = make([]asyncSample, size)
+		}
+	}
+}
+
+// WithWorkers sets how many goroutines send queued samples, 1 by default
+func WithWorkers(workers int) AsyncOption {
+	return func(a *AsyncReporter) {
+		if workers > 0 {
+			a.workers = workers
+		}
+	}
+}
+
+// WithDropPolicy sets the behavior on a full queue, DropOldest by default
+func WithDropPolicy(policy DropPolicy) AsyncOption {
+	return func(a *AsyncReporter) {
+		a.policy = policy
+	}
+}
+
+// NewAsyncReporter wraps reporter and starts the workers, call Shutdown to
+// drain the queue and stop them
+func NewAsyncReporter(reporter Reporter, opts ...AsyncOption) *AsyncReporter {
+	a := &AsyncReporter{
+		reporter: reporter,
+		policy:   DropOldest,
+		workers:  1,
+		queue:    make([]asyncSample, 1024),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	a.notEmpty = sync.NewCond(&a.mu)
+	a.notFull = sync.NewCond(&a.mu)
+	a.idle = sync.NewCond(&a.mu)
+
+	a.wg.Add(a.workers)
+	for i := 0; i < a.workers; i++ {
+		go a.work()
+	}
+	return a
+}
+
+// QueueDepth returns the number of samples waiting for a worker
+func (a *AsyncReporter) QueueDepth() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size
+}
+
+// Dropped returns the number of samples discarded because the queue was full
+func (a *AsyncReporter) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Failed returns the number of samples the wrapped reporter failed to report
+func (a *AsyncReporter) Failed() uint64 {
+	return atomic.LoadUint64(&a.failed)
+}
+
+// ReportQueueMetrics reports AsyncQueueSize and AsyncDroppedSamples directly
+// to the wrapped reporter
+func (a *AsyncReporter) ReportQueueMetrics() error {
+	return joinErrors(
+		a.reporter.ReportGauge(AsyncQueueSize, map[string]string{}, float64(a.QueueDepth())),
+		a.reporter.ReportGauge(AsyncDroppedSamples, map[string]string{}, float64(a.Dropped())),
+	)
+}
+
+func (a *AsyncReporter) ReportCount(metric string, tags map[string]string, count float64) error {
+	return a.enqueue(asyncSample{kind: countSample, metric: metric, tags: copyTags(tags), value: count})
+}
+
+func (a *AsyncReporter) ReportSummary(metric string, tags map[string]string, value float64) error {
+	return a.enqueue(asyncSample{kind: summarySample, metric: metric, tags: copyTags(tags), value: value})
+}
+
+func (a *AsyncReporter) ReportHistogram(metric string, tags map[string]string, value float64) error {
+	return a.enqueue(asyncSample{kind: histogramSample, metric: metric, tags: copyTags(tags), value: value})
+}
+
+func (a *AsyncReporter) ReportGauge(metric string, tags map[string]string, value float64) error {
+	return a.enqueue(asyncSample{kind: gaugeSample, metric: metric, tags: copyTags(tags), value: value})
+}
+
+// RegisterCounter declares counter on the wrapped reporter right away, the
+// registrations are not queued with the samples
+func (a *AsyncReporter) RegisterCounter(counter *config.Counter) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.RegisterCounter(counter) })
+}
+
+// RegisterGauge declares gauge on the wrapped reporter
+func (a *AsyncReporter) RegisterGauge(gauge *config.Gauge) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.RegisterGauge(gauge) })
+}
+
+// RegisterSummary declares summary on the wrapped reporter
+func (a *AsyncReporter) RegisterSummary(summary *config.Summary) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.RegisterSummary(summary) })
+}
+
+// RegisterHistogram declares histogram on the wrapped reporter
+func (a *AsyncReporter) RegisterHistogram(histogram *config.Histogram) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.RegisterHistogram(histogram) })
+}
+
+// Unregister removes metric from the wrapped reporter
+func (a *AsyncReporter) Unregister(metric string) error {
+	return forwardRegistration(a.reporter, func(r Registrar) error { return r.Unregister(metric) })
+}
+
+// Flush blocks until every queued sample has been sent, then flushes the
+// wrapped reporter when it implements Flusher
+func (a *AsyncReporter) Flush() error {
+	a.mu.Lock()
+	for a.size > 0 || a.inFlight > 0 {
+		a.idle.Wait()
+	}
+	a.mu.Unlock()
+
+	if f, ok := a.reporter.(Flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Shutdown stops accepting samples, waits for the workers to drain the queue
+// and shuts down the wrapped reporter
+func (a *AsyncReporter) Shutdown(ctx context.Context) error {
+	a.mu.Lock()
+	a.closed = true
+	a.notEmpty.Broadcast()
+	a.notFull.Broadcast()
+	a.mu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return shutdownReporter(ctx, a.reporter)
+}
+
+func (a *AsyncReporter) enqueue(sample asyncSample) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.closed {
+		return ErrReporterClosed
+	}
+	if a.size == len(a.queue) {
+		switch a.policy {
+		case DropNewest:
+			atomic.AddUint64(&a.dropped, 1)
+			return ErrSampleDropped
+		case Block:
+			for a.size == len(a.queue) && !a.closed {
+				a.notFull.Wait()
+			}
+			if a.closed {
+				return ErrReporterClosed
+			}
+		default:
+			atomic.AddUint64(&a.dropped, 1)
+			a.head = (a.head + 1) % len(a.queue)
+			a.size--
+		}
+	}
+
+	a.queue[(a.head+a.size)%len(a.queue)] = sample
+	a.size++
+	a.notEmpty.Signal()
+	return nil
+}
+
+func (a *AsyncReporter) dequeue() (asyncSample, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for a.size == 0 && !a.closed {
+		a.notEmpty.Wait()
+	}
+	if a.size == 0 {
+		return asyncSample{}, false
+	}
+	sample := a.queue[a.head]
+	a.queue[a.head] = asyncSample{}
+	a.head = (a.head + 1) % len(a.queue)
+	a.size--
+	a.inFlight++
+	a.notFull.Signal()
+	return sample, true
+}
+
+func (a *AsyncReporter) work() {
+	defer a.wg.Done()
+	for {
+		sample, ok := a.dequeue()
+		if !ok {
+			return
+		}
+
+		var err error
+		switch sample.kind {
+		case countSample:
+			err = a.reporter.ReportCount(sample.metric, sample.tags, sample.value)
+		case summarySample:
+			err = a.reporter.ReportSummary(sample.metric, sample.tags, sample.value)
+		case histogramSample:
+			err = a.reporter.ReportHistogram(sample.metric, sample.tags, sample.value)
+		case gaugeSample:
+			err = a.reporter.ReportGauge(sample.metric, sample.tags, sample.value)
+		}
+		if err != nil {
+			atomic.AddUint64(&a.failed, 1)
+		}
+
+		a.mu.Lock()
+		a.inFlight--
+		if a.size == 0 && a.inFlight == 0 {
+			a.idle.Broadcast()
+		}
+		a.mu.Unlock()
+	}
+}
@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedReporter records gauge values, blocking on release once it got the first one
+type gatedReporter struct {
+	mu      sync.Mutex
+	values  []float64
+	started chan struct{}
+	release chan struct{}
+}
+
+func newGatedReporter() *gatedReporter {
+	return &gatedReporter{started: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (g *gatedReporter) ReportGauge(metric string, tags map[string]string, value float64) error {
+	select {
+	case g.started <- struct{}{}:
+		<-g.release
+	default:
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values = append(g.values, value)
+	return nil
+}
+
+func (g *gatedReporter) ReportCount(string, map[string]string, float64) error     { return nil }
+func (g *gatedReporter) ReportSummary(string, map[string]string, float64) error   { return nil }
+func (g *gatedReporter) ReportHistogram(string, map[string]string, float64) error { return nil }
+
+func (g *gatedReporter) reported() []float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]float64{}, g.values...)
+}
+
+func TestAsyncReporterDropPolicies(t *testing.T) {
+	tables := []struct {
+		name     string
+		policy   DropPolicy
+		err      error
+		expected []float64
+	}{
+		{"drop-oldest", DropOldest, nil, []float64{1, 3, 4}},
+		{"drop-newest", DropNewest, ErrSampleDropped, []float64{1, 2, 3}},
+	}
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			gated := newGatedReporter()
+			a := NewAsyncReporter(gated, WithQueueSize(2), WithDropPolicy(table.policy))
+
+			assert.NoError(t, a.ReportGauge(ConnectedClients, nil, 1))
+			<-gated.started
+			assert.NoError(t, a.ReportGauge(ConnectedClients, nil, 2))
+			assert.NoError(t, a.ReportGauge(ConnectedClients, nil, 3))
+			assert.Equal(t, table.err, a.ReportGauge(ConnectedClients, nil, 4))
+			assert.Equal(t, 2, a.QueueDepth())
+			assert.Equal(t, uint64(1), a.Dropped())
+
+			close(gated.release)
+			assert.NoError(t, a.Flush())
+			assert.Equal(t, table.expected, gated.reported())
+			assert.NoError(t, a.Shutdown(context.Background()))
+		})
+	}
+
+	t.Run("block", func(t *testing.T) {
+		gated := newGatedReporter()
+		a := NewAsyncReporter(gated, WithQueueSize(1), WithDropPolicy(Block))
+
+		assert.NoError(t, a.ReportGauge(ConnectedClients, nil, 1))
+		<-gated.started
+		assert.NoError(t, a.ReportGauge(ConnectedClients, nil, 2))
+
+		blocked := make(chan error)
+		go func() {
+			blocked <- a.ReportGauge(ConnectedClients, nil, 3)
+		}()
+		select {
+		case <-blocked:
+			t.Fatal("report did not block on a full queue")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(gated.release)
+		assert.NoError(t, <-blocked)
+		assert.NoError(t, a.Shutdown(context.Background()))
+		assert.Equal(t, []float64{1, 2, 3}, gated.reported())
+		assert.Equal(t, uint64(0), a.Dropped())
+	})
+}
+
+func TestAsyncReporterShutdown(t *testing.T) {
+	gated := newGatedReporter()
+	a := NewAsyncReporter(gated, WithWorkers(4))
+
+	assert.NoError(t, a.ReportGauge(ConnectedClients, nil, 1))
+	<-gated.started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, a.Shutdown(ctx))
+	assert.Equal(t, ErrReporterClosed, a.ReportGauge(ConnectedClients, nil, 2))
+
+	close(gated.release)
+	assert.NoError(t, a.Shutdown(context.Background()))
+	assert.Equal(t, []float64{1}, gated.reported())
+}
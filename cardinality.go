@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// OverflowLabelValue replaces every label value of the series reported over
+// the cardinality limit of their metric
+const OverflowLabelValue = "__overflow__"
+
+// cardinalityGuard tracks the distinct label sets of each metric, up to its
+// limit, and collapses the ones over it into a single overflow series
+type cardinalityGuard struct {
+	defaultLimit int
+	limits       map[string]int
+
+	mu   sync.Mutex
+	seen map[string]map[uint64]struct{}
+}
+
+func newCardinalityGuard(defaultLimit int, limits map[string]int) *cardinalityGuard {
+	if defaultLimit <= 0 && len(limits) == 0 {
+		return nil
+	}
+	return &cardinalityGuard{
+		defaultLimit: defaultLimit,
+		limits:       limits,
+		seen:         make(map[string]map[uint64]struct{}),
+	}
+}
+
+func (g *cardinalityGuard) limit(metric string) int {
+	if limit, ok := g.limits[metric]; ok {
+		return limit
+	}
+	return g.defaultLimit
+}
+
+// admit returns labels when the series is known or fits in the limit, and an
+// overflow copy of it otherwise. The series over the limit are not tracked,
+// collapsed is true for every sample collapsed
+func (g *cardinalityGuard) admit(metric string, labels map[string]string) (admitted map[string]string, collapsed bool) {
+	limit := g.limit(metric)
+	if limit <= 0 {
+		return labels, false
+	}
+	h := fnv.New64a()
+	h.Write([]byte(seriesKey("", labels)))
+	key := h.Sum64()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	seen, ok := g.seen[metric]
+	if !ok {
+		seen = make(map[uint64]struct{})
+		g.seen[metric] = seen
+	}
+	if _, ok := seen[key]; ok {
+		return labels, false
+	}
+	if len(seen) < limit {
+		seen[key] = struct{}{}
+		return labels, false
+	}
+
+	overflow := make(map[string]string, len(labels))
+	for k := range labels {
+		overflow[k] = OverflowLabelValue
+	}
+	return overflow, true
+}
+
+// forget drops the series tracked for metric, once it is unregistered
+func (g *cardinalityGuard) forget(metric string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.seen, metric)
+}
@@ -3,6 +3,9 @@ package metrics
 const (
 	// ResponseTime reports the response time of handlers and rpc
 	ResponseTime = "response_time_ns"
+	// ResponseTimeHistogram is the exposed name of the ResponseTime histogram, it can not
+	// share the summary name as both live in the same registry
+	ResponseTimeHistogram = "response_time_ns_histogram"
 	// ConnectedClients represents the number of current connected clients in frontend servers
 	ConnectedClients = "connected_clients"
 	// CountServers counts the number of servers of different types
@@ -19,12 +22,54 @@ const (
 	HeapSize = "heapsize"
 	// HeapObjects reports the number of allocated heap objects
 	HeapObjects = "heapobjects"
+	// GCPauses reports quantiles of the stop-the-world GC pause latencies since the last collection
+	GCPauses = "gc_pauses_seconds"
+	// GCCycles reports the number of completed GC cycles
+	GCCycles = "gc_cycles_total"
+	// SchedLatencies reports quantiles of the time goroutines spent runnable before running since the last collection
+	SchedLatencies = "sched_latencies_seconds"
+	// HeapGoal reports the heap size target for the end of the GC cycle
+	HeapGoal = "heap_goal_bytes"
+	// StackMemory reports the memory used by goroutine stacks
+	StackMemory = "stack_bytes"
+	// OSMemory reports all the memory mapped by the Go runtime from the OS
+	OSMemory = "os_memory_bytes"
+	// CgoCalls reports the number of cgo calls made by the process
+	CgoCalls = "cgo_calls_total"
+	// GoMaxProcs reports the current GOMAXPROCS value
+	GoMaxProcs = "gomaxprocs"
+	// ProcessCPUSeconds reports the user and system CPU time spent by the process
+	ProcessCPUSeconds = "process_cpu_seconds"
+	// ProcessResidentMemory reports the resident memory size of the process
+	ProcessResidentMemory = "process_resident_memory_bytes"
+	// ProcessVirtualMemory reports the virtual memory size of the process
+	ProcessVirtualMemory = "process_virtual_memory_bytes"
+	// ProcessOpenFDs reports the number of open file descriptors
+	ProcessOpenFDs = "process_open_fds"
+	// ProcessMaxFDs reports the soft limit of open file descriptors, 0 when unlimited
+	ProcessMaxFDs = "process_max_fds"
+	// ProcessThreads reports the number of OS threads of the process
+	ProcessThreads = "process_threads"
+	// ProcessStartTime reports the start time of the process since unix epoch in seconds
+	ProcessStartTime = "process_start_time_seconds"
 	// WorkerJobsTotal reports the number of executed jobs
 	WorkerJobsTotal = "worker_jobs_total"
 	// WorkerJobsRetry reports the number of retried jobs
 	WorkerJobsRetry = "worker_jobs_retry_total"
 	// WorkerQueueSize reports the queue size on worker
 	WorkerQueueSize = "worker_queue_size"
+	// AsyncQueueSize reports the number of samples waiting in an AsyncReporter queue
+	AsyncQueueSize = "async_queue_size"
+	// AsyncDroppedSamples reports the number of samples an AsyncReporter dropped on a full queue
+	AsyncDroppedSamples = "async_dropped_samples"
+	// CardinalityOverflow reports the number of samples collapsed by the cardinality limit
+	CardinalityOverflow = "cardinality_overflow"
+	// MalformedContext reports the number of contexts missing the start time or route
+	MalformedContext = "malformed_context"
+	// LabelMismatch reports the number of samples whose labels did not match the metric declared ones
+	LabelMismatch = "label_mismatch"
+	// CustomMetricsReloads reports the number of CustomMetricsSpec reloads, by status
+	CustomMetricsReloads = "custom_metrics_reloads"
 	// ExceededRateLimiting reports the number of requests made in a connection
 	// after the rate limit was exceeded
 	ExceededRateLimiting = "exceeded_rate_limiting"
@@ -35,3 +80,6 @@ const (
 	RouteKey      = "req-route"
 	MetricTagsKey = "metric-tags"
 )
+
+// UnknownRoute is the route tag of requests whose context has no route
+const UnknownRoute = "unknown"
@@ -1,8 +1,93 @@
 package metrics
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 var (
 	ErrMetricNotKnown = errors.New("the provided metric does not exist")
 	ErrNotImplemented = errors.New("method not implemented")
+	ErrReporterClosed = errors.New("the reporter was shut down")
+	ErrSampleDropped  = errors.New("the sample was dropped, the reporter queue is full")
+	ErrMalformedCtx   = errors.New("the context does not hold the expected metrics values")
+	ErrLabelMismatch  = errors.New("the labels do not match the metric declared labels")
+	ErrMetricConflict = errors.New("the metric conflicts with an already declared one")
+	ErrInvalidMetric  = errors.New("the metric definition is invalid")
+	ErrBuiltinMetric  = errors.New("built-in metrics can not be unregistered")
 )
+
+// ContextError describes a propagated context value that is missing or does
+// not have the expected type, it unwraps to ErrMalformedCtx
+type ContextError struct {
+	Key   string
+	Value interface{}
+}
+
+func (e *ContextError) Error() string {
+	if e.Value == nil {
+		return fmt.Sprintf("%s is missing from the propagated context", e.Key)
+	}
+	return fmt.Sprintf("%s has unexpected type %T in the propagated context", e.Key, e.Value)
+}
+
+func (e *ContextError) Unwrap() error {
+	return ErrMalformedCtx
+}
+
+// joinedError holds several errors, it unwraps to all of them
+type joinedError struct {
+	errs []error
+}
+
+func (e *joinedError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (e *joinedError) Unwrap() []error {
+	return e.errs
+}
+
+// Is reports whether any joined error matches target
+func (e *joinedError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first joined error matching target, Go 1.19 errors.As not
+// unwrapping []error
+func (e *joinedError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// joinErrors returns nil when every err is nil, the error itself when there is
+// only one and a joinedError otherwise
+func joinErrors(errs ...error) error {
+	nonNil := make([]error, 0, len(errs))
+	for _, err := range errs {
+		if err != nil {
+			nonNil = append(nonNil, err)
+		}
+	}
+	switch len(nonNil) {
+	case 0:
+		return nil
+	case 1:
+		return nonNil[0]
+	}
+	return &joinedError{errs: nonNil}
+}
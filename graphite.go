@@ -0,0 +1,430 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	logger "github.com/gotechbook/gotechbook-framework-logger"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// graphitePendingLimit bounds the bytes kept for the next flush when sending fails
+const graphitePendingLimit = 1 << 20
+
+// GraphiteProtocol selects the carbon receiver GraphiteReporter sends to
+type GraphiteProtocol int
+
+const (
+	// GraphitePlaintext sends "path value timestamp" lines (default)
+	GraphitePlaintext GraphiteProtocol = iota
+	// GraphitePickle sends pickled batches to the carbon pickle receiver
+	GraphitePickle
+)
+
+var (
+	graphiteNodeSanitizer = strings.NewReplacer(".", "_", " ", "_", ";", "_", "=", "_", "~", "_")
+	graphiteTagSanitizer  = strings.NewReplacer(" ", "_", ";", "_", "=", "_", "~", "_", "!", "_", "^", "_")
+)
+
+// GraphiteConfig is the configuration of GraphiteReporter, the counterpart of
+// the GoTechBookFrameworkMetricsStatsd* settings of config.Metrics
+type GraphiteConfig struct {
+	// Host is the host:port of the carbon receiver
+	Host string
+	// Prefix is prepended to every path
+	Prefix string
+	// Tagged sends Graphite 1.1 tagged series (name;tag=value) instead of
+	// appending the sorted tags to the path as .key.value nodes
+	Tagged   bool
+	Protocol GraphiteProtocol
+	// FlushInterval is how often aggregates are sent, 10s when zero
+	FlushInterval time.Duration
+	// Backoff is the delay before reconnecting after a failure, doubled on
+	// every consecutive failure up to MaxBackoff. 1s and 1m when zero
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// WriteTimeout bounds every write to the carbon receiver, 10s when zero
+	WriteTimeout time.Duration
+}
+
+// GraphiteReporter is a Reporter aggregating the reports of each flush
+// interval and sending them to carbon over TCP: counts are summed, gauges keep
+// their last value, summaries and histograms are sent as the count, sum, min
+// and max of their samples under .count, .sum, .min and .max
+type GraphiteReporter struct {
+	cfg         GraphiteConfig
+	defaultTags map[string]string
+	dial        func(addr string) (net.Conn, error)
+
+	mu     sync.Mutex
+	series map[string]*graphiteSeries
+
+	// sendMu guards the connection state below
+	sendMu      sync.Mutex
+	conn        net.Conn
+	pending     []byte
+	backoff     time.Duration
+	nextAttempt time.Time
+	dropped     uint64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type graphiteKind int
+
+const (
+	graphiteCount graphiteKind = iota
+	graphiteGauge
+	graphiteSamples
+)
+
+type graphiteSeries struct {
+	kind     graphiteKind
+	metric   string
+	tags     map[string]string
+	value    float64
+	count    float64
+	min, max float64
+}
+
+type graphitePoint struct {
+	path  string
+	value float64
+}
+
+// GraphiteOption configures a GraphiteReporter
+type GraphiteOption func(*GraphiteReporter)
+
+// WithGraphiteDialer replaces net.Dial to connect to the carbon receiver
+func WithGraphiteDialer(dial func(addr string) (net.Conn, error)) GraphiteOption {
+	return func(g *GraphiteReporter) {
+		g.dial = dial
+	}
+}
+
+// NewGraphiteReporter returns a GraphiteReporter tagging every series with
+// serverType and GoTechBookFrameworkMetricsConstTags. It connects on the first
+// flush, call Shutdown to send the last aggregates and close the connection
+func NewGraphiteReporter(metrics config.Metrics, cfg GraphiteConfig, serverType string, opts ...GraphiteOption) *GraphiteReporter {
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 10 * time.Second
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	g := &GraphiteReporter{
+		cfg:         cfg,
+		defaultTags: map[string]string{"serverType": serverType},
+		dial: func(addr string) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, 5*time.Second)
+		},
+		series: make(map[string]*graphiteSeries),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for k, v := range metrics.GoTechBookFrameworkMetricsConstTags {
+		g.defaultTags[k] = v
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	go g.loop()
+	return g
+}
+
+func (g *GraphiteReporter) loop() {
+	defer close(g.done)
+	ticker := time.NewTicker(g.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			if err := g.Flush(); err != nil {
+				logger.Log.Errorf("failed to send graphite metrics: %q", err)
+			}
+		}
+	}
+}
+
+func (g *GraphiteReporter) get(kind graphiteKind, metric string, tags map[string]string) *graphiteSeries {
+	key := seriesKey(metric, tags)
+	s, ok := g.series[key]
+	if !ok || s.kind != kind {
+		s = &graphiteSeries{kind: kind, metric: metric, tags: copyTags(tags), min: math.Inf(1), max: math.Inf(-1)}
+		g.series[key] = s
+	}
+	return s
+}
+
+func (g *GraphiteReporter) ReportCount(metric string, tags map[string]string, count float64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(graphiteCount, metric, tags).value += count
+	return nil
+}
+
+func (g *GraphiteReporter) ReportGauge(metric string, tags map[string]string, value float64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(graphiteGauge, metric, tags).value = value
+	return nil
+}
+
+func (g *GraphiteReporter) ReportSummary(metric string, tags map[string]string, value float64) error {
+	g.observe(metric, tags, value)
+	return nil
+}
+
+func (g *GraphiteReporter) ReportHistogram(metric string, tags map[string]string, value float64) error {
+	g.observe(metric, tags, value)
+	return nil
+}
+
+func (g *GraphiteReporter) observe(metric string, tags map[string]string, value float64) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s := g.get(graphiteSamples, metric, tags)
+	s.value += value
+	s.count++
+	s.min = math.Min(s.min, value)
+	s.max = math.Max(s.max, value)
+}
+
+// path names a series as prefix.metric followed by the sorted tags, either
+// as .key.value nodes or as ;key=value pairs when Tagged
+func (g *GraphiteReporter) path(metric, suffix string, tags map[string]string) string {
+	merged := make(map[string]string, len(g.defaultTags)+len(tags))
+	for k, v := range g.defaultTags {
+		merged[k] = v
+	}
+	for k, v := range tags {
+		merged[k] = v
+	}
+	keys := make([]string, 0, len(merged))
+	for k, v := range merged {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	if g.cfg.Prefix != "" {
+		b.WriteString(strings.TrimSuffix(g.cfg.Prefix, "."))
+		b.WriteByte('.')
+	}
+	b.WriteString(graphiteNodeSanitizer.Replace(metric))
+	if g.cfg.Tagged {
+		b.WriteString(suffix)
+		for _, k := range keys {
+			b.WriteByte(';')
+			b.WriteString(graphiteTagSanitizer.Replace(k))
+			b.WriteByte('=')
+			b.WriteString(graphiteTagSanitizer.Replace(merged[k]))
+		}
+		return b.String()
+	}
+	for _, k := range keys {
+		b.WriteByte('.')
+		b.WriteString(graphiteNodeSanitizer.Replace(k))
+		b.WriteByte('.')
+		b.WriteString(graphiteNodeSanitizer.Replace(merged[k]))
+	}
+	b.WriteString(suffix)
+	return b.String()
+}
+
+func (g *GraphiteReporter) points(series map[string]*graphiteSeries) []graphitePoint {
+	points := make([]graphitePoint, 0, len(series))
+	for _, s := range series {
+		switch s.kind {
+		case graphiteSamples:
+			points = append(points,
+				graphitePoint{g.path(s.metric, ".count", s.tags), s.count},
+				graphitePoint{g.path(s.metric, ".sum", s.tags), s.value},
+				graphitePoint{g.path(s.metric, ".min", s.tags), s.min},
+				graphitePoint{g.path(s.metric, ".max", s.tags), s.max},
+			)
+		default:
+			points = append(points, graphitePoint{g.path(s.metric, "", s.tags), s.value})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].path < points[j].path })
+	return points
+}
+
+// Flush sends the aggregates of the current interval and starts new ones.
+// When sending fails the payload is kept for the next flush, up to
+// graphitePendingLimit bytes, and the connection is retried once the backoff
+// has elapsed
+func (g *GraphiteReporter) Flush() error {
+	g.mu.Lock()
+	series := g.series
+	g.series = make(map[string]*graphiteSeries)
+	g.mu.Unlock()
+
+	var payload []byte
+	if len(series) > 0 {
+		points := g.points(series)
+		ts := time.Now().Unix()
+		if g.cfg.Protocol == GraphitePickle {
+			payload = encodeGraphitePickle(points, ts)
+		} else {
+			payload = encodeGraphitePlaintext(points, ts)
+		}
+	}
+
+	g.sendMu.Lock()
+	defer g.sendMu.Unlock()
+	payload = append(g.pending, payload...)
+	g.pending = nil
+	if len(payload) == 0 {
+		return nil
+	}
+	if err := g.send(payload); err != nil {
+		if len(payload) <= graphitePendingLimit {
+			g.pending = payload
+		} else {
+			atomic.AddUint64(&g.dropped, 1)
+			logger.Log.Errorf("dropped %d bytes of unsent graphite metrics", len(payload))
+		}
+		return err
+	}
+	return nil
+}
+
+func (g *GraphiteReporter) send(payload []byte) error {
+	if g.conn == nil {
+		if now := time.Now(); now.Before(g.nextAttempt) {
+			return fmt.Errorf("reconnecting to graphite in %s", g.nextAttempt.Sub(now))
+		}
+		conn, err := g.dial(g.cfg.Host)
+		if err != nil {
+			g.fail()
+			return err
+		}
+		g.conn = conn
+	}
+	err := g.conn.SetWriteDeadline(time.Now().Add(g.cfg.WriteTimeout))
+	if err == nil {
+		_, err = g.conn.Write(payload)
+	}
+	if err != nil {
+		g.conn.Close()
+		g.conn = nil
+		g.fail()
+		return err
+	}
+	g.backoff = 0
+	return nil
+}
+
+// Dropped returns the number of unsent payloads discarded for exceeding
+// graphitePendingLimit
+func (g *GraphiteReporter) Dropped() uint64 {
+	return atomic.LoadUint64(&g.dropped)
+}
+
+// fail schedules the next connection attempt
+func (g *GraphiteReporter) fail() {
+	if g.backoff == 0 {
+		g.backoff = g.cfg.Backoff
+	} else if g.backoff *= 2; g.backoff > g.cfg.MaxBackoff {
+		g.backoff = g.cfg.MaxBackoff
+	}
+	g.nextAttempt = time.Now().Add(g.backoff)
+}
+
+// Shutdown stops the flush loop, sends the last aggregates and closes the
+// connection
+func (g *GraphiteReporter) Shutdown(ctx context.Context) error {
+	g.once.Do(func() {
+		close(g.stop)
+	})
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := g.Flush()
+		g.sendMu.Lock()
+		if g.conn != nil {
+			err = joinErrors(err, g.conn.Close())
+			g.conn = nil
+		}
+		g.sendMu.Unlock()
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func encodeGraphitePlaintext(points []graphitePoint, ts int64) []byte {
+	var buf bytes.Buffer
+	for _, p := range points {
+		buf.WriteString(p.path)
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatFloat(p.value, 'f', -1, 64))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.FormatInt(ts, 10))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// encodeGraphitePickle encodes points as the length prefixed pickle (protocol
+// 2) of [(path, (timestamp, value)), ...] the carbon pickle receiver expects
+func encodeGraphitePickle(points []graphitePoint, ts int64) []byte {
+	var buf bytes.Buffer
+	buf.Write([]byte{0, 0, 0, 0}) // length, set below
+	buf.Write([]byte{0x80, 2})    // PROTO 2
+	buf.WriteByte(']')            // EMPTY_LIST
+	buf.WriteByte('(')            // MARK
+	var scratch [8]byte
+	for _, p := range points {
+		buf.WriteByte('X') // BINUNICODE
+		binary.LittleEndian.PutUint32(scratch[:4], uint32(len(p.path)))
+		buf.Write(scratch[:4])
+		buf.WriteString(p.path)
+		buf.WriteByte('G') // BINFLOAT, BININT would overflow in 2038
+		binary.BigEndian.PutUint64(scratch[:], math.Float64bits(float64(ts)))
+		buf.Write(scratch[:])
+		buf.WriteByte('G') // BINFLOAT
+		binary.BigEndian.PutUint64(scratch[:], math.Float64bits(p.value))
+		buf.Write(scratch[:])
+		buf.WriteByte(0x86) // TUPLE2 (timestamp, value)
+		buf.WriteByte(0x86) // TUPLE2 (path, (timestamp, value))
+	}
+	buf.WriteByte('e') // APPENDS
+	buf.WriteByte('.') // STOP
+	payload := buf.Bytes()
+	binary.BigEndian.PutUint32(payload, uint32(len(payload)-4))
+	return payload
+}
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestGraphiteListener(t *testing.T) (net.Listener, chan net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	return listener, conns
+}
+
+func reportGraphiteSamples(t *testing.T, g *GraphiteReporter) {
+	t.Helper()
+	tags := map[string]string{"route": "room.join"}
+	assert.NoError(t, g.ReportCount(ExceededRateLimiting, tags, 1))
+	assert.NoError(t, g.ReportCount(ExceededRateLimiting, tags, 2))
+	assert.NoError(t, g.ReportGauge(ConnectedClients, map[string]string{}, 4))
+	assert.NoError(t, g.ReportGauge(ConnectedClients, map[string]string{}, 3))
+	assert.NoError(t, g.ReportSummary(ResponseTime, tags, 10))
+	assert.NoError(t, g.ReportSummary(ResponseTime, tags, 30))
+}
+
+func TestGraphiteReporterPlaintext(t *testing.T) {
+	tables := []struct {
+		name     string
+		tagged   bool
+		expected []string
+	}{
+		{"dotted", false, []string{
+			"game.connected_clients.region.us-east.serverType.connector 3",
+			"game.exceeded_rate_limiting.region.us-east.route.room_join.serverType.connector 3",
+			"game.response_time_ns.region.us-east.route.room_join.serverType.connector.count 2",
+			"game.response_time_ns.region.us-east.route.room_join.serverType.connector.max 30",
+			"game.response_time_ns.region.us-east.route.room_join.serverType.connector.min 10",
+			"game.response_time_ns.region.us-east.route.room_join.serverType.connector.sum 40",
+		}},
+		{"tagged", true, []string{
+			"game.connected_clients;region=us-east;serverType=connector 3",
+			"game.exceeded_rate_limiting;region=us-east;route=room.join;serverType=connector 3",
+			"game.response_time_ns.count;region=us-east;route=room.join;serverType=connector 2",
+			"game.response_time_ns.max;region=us-east;route=room.join;serverType=connector 30",
+			"game.response_time_ns.min;region=us-east;route=room.join;serverType=connector 10",
+			"game.response_time_ns.sum;region=us-east;route=room.join;serverType=connector 40",
+		}},
+	}
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			listener, conns := newTestGraphiteListener(t)
+			defer listener.Close()
+
+			g := NewGraphiteReporter(config.Metrics{
+				GoTechBookFrameworkMetricsConstTags: map[string]string{"region": "us-east"},
+			}, GraphiteConfig{Host: listener.Addr().String(), Prefix: "game", Tagged: table.tagged}, "connector")
+			reportGraphiteSamples(t, g)
+			assert.NoError(t, g.Shutdown(context.Background()))
+
+			conn := <-conns
+			defer conn.Close()
+			body, err := io.ReadAll(conn)
+			assert.NoError(t, err)
+			lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+			if assert.Len(t, lines, len(table.expected)) {
+				for i, line := range lines {
+					assert.Equal(t, table.expected[i], line[:strings.LastIndexByte(line, ' ')])
+				}
+			}
+		})
+	}
+}
+
+// decodeGraphitePickle decodes the opcodes written by encodeGraphitePickle
+func decodeGraphitePickle(t *testing.T, r *bufio.Reader) map[string]float64 {
+	points, _ := decodeGraphitePickleTimestamp(t, r)
+	return points
+}
+
+// decodeGraphitePickleTimestamp also returns the timestamp of the last point
+func decodeGraphitePickleTimestamp(t *testing.T, r *bufio.Reader) (map[string]float64, float64) {
+	t.Helper()
+	var size uint32
+	assert.NoError(t, binary.Read(r, binary.BigEndian, &size))
+	payload := make([]byte, size)
+	_, err := io.ReadFull(r, payload)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []byte{0x80, 2, ']', '('}, payload[:4])
+	payload = payload[4:]
+	points := map[string]float64{}
+	var ts float64
+	for payload[0] == 'X' {
+		n := binary.LittleEndian.Uint32(payload[1:])
+		path := string(payload[5 : 5+n])
+		payload = payload[5+n:]
+		assert.Equal(t, byte('G'), payload[0])
+		ts = math.Float64frombits(binary.BigEndian.Uint64(payload[1:]))
+		assert.Equal(t, byte('G'), payload[9])
+		points[path] = math.Float64frombits(binary.BigEndian.Uint64(payload[10:]))
+		assert.Equal(t, []byte{0x86, 0x86}, payload[18:20])
+		payload = payload[20:]
+	}
+	assert.Equal(t, []byte("e."), payload)
+	return points, ts
+}
+
+func TestEncodeGraphitePickleTimestamp(t *testing.T) {
+	// past 2038, the 32 bits timestamps overflow
+	ts := time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	payload := encodeGraphitePickle([]graphitePoint{{path: "players", value: 1}}, ts)
+	points, decoded := decodeGraphitePickleTimestamp(t, bufio.NewReader(bytes.NewReader(payload)))
+	assert.Equal(t, map[string]float64{"players": 1}, points)
+	assert.Equal(t, float64(ts), decoded)
+}
+
+func TestGraphiteReporterPickle(t *testing.T) {
+	listener, conns := newTestGraphiteListener(t)
+	defer listener.Close()
+
+	g := NewGraphiteReporter(config.Metrics{}, GraphiteConfig{
+		Host:     listener.Addr().String(),
+		Tagged:   true,
+		Protocol: GraphitePickle,
+	}, "connector")
+	reportGraphiteSamples(t, g)
+	assert.NoError(t, g.Flush())
+	assert.NoError(t, g.ReportCount(ExceededRateLimiting, map[string]string{}, 5))
+	assert.NoError(t, g.Shutdown(context.Background()))
+
+	conn := <-conns
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	points := decodeGraphitePickle(t, r)
+	assert.Len(t, points, 6)
+	assert.Equal(t, float64(3), points["exceeded_rate_limiting;route=room.join;serverType=connector"])
+	assert.Equal(t, float64(40), points["response_time_ns.sum;route=room.join;serverType=connector"])
+	assert.Equal(t, map[string]float64{"exceeded_rate_limiting;serverType=connector": 5}, decodeGraphitePickle(t, r))
+}
+
+func TestGraphiteReporterReconnect(t *testing.T) {
+	listener, conns := newTestGraphiteListener(t)
+	defer listener.Close()
+
+	var dials int
+	dial := func(addr string) (net.Conn, error) {
+		dials++
+		if dials == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return net.Dial("tcp", addr)
+	}
+	g := NewGraphiteReporter(config.Metrics{}, GraphiteConfig{
+		Host:    listener.Addr().String(),
+		Backoff: 50 * time.Millisecond,
+	}, "connector", WithGraphiteDialer(dial))
+
+	assert.NoError(t, g.ReportCount(ExceededRateLimiting, map[string]string{}, 1))
+	assert.Error(t, g.Flush())
+	assert.NoError(t, g.ReportCount(ExceededRateLimiting, map[string]string{}, 2))
+	assert.Error(t, g.Flush(), "still backing off")
+	assert.Equal(t, 1, dials)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.NoError(t, g.Flush())
+	assert.Equal(t, 2, dials)
+	assert.NoError(t, g.Shutdown(context.Background()))
+
+	conn := <-conns
+	defer conn.Close()
+	body, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+	if assert.Len(t, lines, 2, "the payloads kept while disconnected are sent in order") {
+		assert.True(t, strings.HasPrefix(lines[0], "exceeded_rate_limiting.serverType.connector 1 "))
+		assert.True(t, strings.HasPrefix(lines[1], "exceeded_rate_limiting.serverType.connector 2 "))
+	}
+}
+
+func TestGraphiteReporterDropped(t *testing.T) {
+	dial := func(addr string) (net.Conn, error) {
+		return nil, errors.New("connection refused")
+	}
+	g := NewGraphiteReporter(config.Metrics{}, GraphiteConfig{Host: "carbon:2003"}, "connector", WithGraphiteDialer(dial))
+	defer g.Shutdown(context.Background())
+
+	assert.NoError(t, g.ReportCount(ExceededRateLimiting, map[string]string{}, 1))
+	assert.Error(t, g.Flush())
+	assert.Equal(t, uint64(0), g.Dropped())
+
+	assert.NoError(t, g.ReportCount(strings.Repeat("m", graphitePendingLimit), map[string]string{}, 1))
+	assert.Error(t, g.Flush())
+	assert.Equal(t, uint64(1), g.Dropped())
+}
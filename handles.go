@@ -0,0 +1,372 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Counter is a counter whose label values are bound once with With
+type Counter interface {
+	With(labelValues ...string) BoundCounter
+}
+
+// BoundCounter is a Counter with its label values bound
+type BoundCounter interface {
+	Add(delta float64)
+}
+
+// Gauge is a gauge whose label values are bound once with With
+type Gauge interface {
+	With(labelValues ...string) BoundGauge
+}
+
+// BoundGauge is a Gauge with its label values bound
+type BoundGauge interface {
+	Set(value float64)
+}
+
+// Timer is a duration summary whose label values are bound once with With
+type Timer interface {
+	With(labelValues ...string) BoundTimer
+}
+
+// BoundTimer is a Timer with its label values bound
+type BoundTimer interface {
+	Observe(d time.Duration)
+}
+
+// Histogram is a histogram whose label values are bound once with With
+type Histogram interface {
+	With(labelValues ...string) BoundHistogram
+}
+
+// BoundHistogram is a Histogram with its label values bound
+type BoundHistogram interface {
+	Observe(value float64)
+}
+
+// HandleProvider builds typed handles, labelNames gives the order of the
+// values passed to With
+type HandleProvider interface {
+	Counter(metric string, labelNames ...string) (Counter, error)
+	Gauge(metric string, labelNames ...string) (Gauge, error)
+	Timer(metric string, labelNames ...string) (Timer, error)
+	Histogram(metric string, labelNames ...string) (Histogram, error)
+}
+
+// noopBound and noopTimer are returned by With when the label values are rejected
+type noopBound struct{}
+
+func (noopBound) Add(float64)     {}
+func (noopBound) Set(float64)     {}
+func (noopBound) Observe(float64) {}
+
+type noopTimer struct{}
+
+func (noopTimer) Observe(time.Duration) {}
+
+// promHandle binds label values to a Prometheus vector, going through the
+// label reconciliation and cardinality guard once per With
+type promHandle struct {
+	p          *PrometheusReporter
+	metric     string
+	labelNames []string
+	declared   []string
+}
+
+func (p *PrometheusReporter) newHandle(metric string, declared []string, labelNames []string) (*promHandle, error) {
+	for _, name := range labelNames {
+		if !containsLabel(declared, name) {
+			return nil, fmt.Errorf("%w: %s does not declare %s", ErrLabelMismatch, metric, name)
+		}
+	}
+	return &promHandle{p: p, metric: metric, labelNames: labelNames, declared: declared}, nil
+}
+
+func (h *promHandle) labels(labelValues []string) (prometheus.Labels, bool) {
+	h.p.mu.RLock()
+	defer h.p.mu.RUnlock()
+	if len(labelValues) != len(h.labelNames) {
+		h.p.countLabelMismatch(h.metric, "arity")
+		return nil, false
+	}
+	labels := make(map[string]string, len(h.labelNames))
+	for i, name := range h.labelNames {
+		labels[name] = labelValues[i]
+	}
+	labels, err := h.p.reconcileLabels(h.metric, h.declared, labels)
+	if err != nil {
+		return nil, false
+	}
+	return h.p.limitCardinality(h.metric, labels), true
+}
+
+type promCounter struct {
+	*promHandle
+	vec *prometheus.CounterVec
+}
+
+func (c *promCounter) With(labelValues ...string) BoundCounter {
+	labels, ok := c.labels(labelValues)
+	if !ok {
+		return noopBound{}
+	}
+	counter, err := c.vec.GetMetricWith(labels)
+	if err != nil {
+		return noopBound{}
+	}
+	return counter
+}
+
+type promGauge struct {
+	*promHandle
+	vec *prometheus.GaugeVec
+}
+
+func (g *promGauge) With(labelValues ...string) BoundGauge {
+	labels, ok := g.labels(labelValues)
+	if !ok {
+		return noopBound{}
+	}
+	gauge, err := g.vec.GetMetricWith(labels)
+	if err != nil {
+		return noopBound{}
+	}
+	return gauge
+}
+
+type promObserver struct {
+	*promHandle
+	vec prometheus.ObserverVec
+}
+
+func (o *promObserver) With(labelValues ...string) BoundHistogram {
+	labels, ok := o.labels(labelValues)
+	if !ok {
+		return noopBound{}
+	}
+	observer, err := o.vec.GetMetricWith(labels)
+	if err != nil {
+		return noopBound{}
+	}
+	return observer
+}
+
+type promTimer struct {
+	*promObserver
+}
+
+func (t *promTimer) With(labelValues ...string) BoundTimer {
+	observer := t.promObserver.With(labelValues...)
+	if _, ok := observer.(noopBound); ok {
+		return noopTimer{}
+	}
+	return &promBoundTimer{observer: observer, legacyUnits: t.p.legacyUnits}
+}
+
+type promBoundTimer struct {
+	observer    BoundHistogram
+	legacyUnits bool
+}
+
+func (t *promBoundTimer) Observe(d time.Duration) {
+	if t.legacyUnits {
+		t.observer.Observe(float64(d.Nanoseconds()))
+		return
+	}
+	t.observer.Observe(d.Seconds())
+}
+
+// Counter returns a handle on the counter metric
+func (p *PrometheusReporter) Counter(metric string, labelNames ...string) (Counter, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	vec := p.countReportersMap[metric]
+	if vec == nil {
+		return nil, ErrMetricNotKnown
+	}
+	h, err := p.newHandle(metric, p.countLabels[metric], labelNames)
+	if err != nil {
+		return nil, err
+	}
+	return &promCounter{promHandle: h, vec: vec}, nil
+}
+
+// Gauge returns a handle on the gauge metric
+func (p *PrometheusReporter) Gauge(metric string, labelNames ...string) (Gauge, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	vec := p.gaugeReportersMap[metric]
+	if vec == nil {
+		return nil, ErrMetricNotKnown
+	}
+	h, err := p.newHandle(metric, p.gaugeLabels[metric], labelNames)
+	if err != nil {
+		return nil, err
+	}
+	return &promGauge{promHandle: h, vec: vec}, nil
+}
+
+// Timer returns a handle on the summary metric, durations are observed in
+// seconds, or nanoseconds with legacy units
+func (p *PrometheusReporter) Timer(metric string, labelNames ...string) (Timer, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	vec := p.summaryReportersMap[metric]
+	if vec == nil {
+		return nil, ErrMetricNotKnown
+	}
+	h, err := p.newHandle(metric, p.summaryLabels[metric], labelNames)
+	if err != nil {
+		return nil, err
+	}
+	return &promTimer{promObserver: &promObserver{promHandle: h, vec: vec}}, nil
+}
+
+// Histogram returns a handle on the histogram metric
+func (p *PrometheusReporter) Histogram(metric string, labelNames ...string) (Histogram, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	vec := p.histogramReportersMap[metric]
+	if vec == nil {
+		return nil, ErrMetricNotKnown
+	}
+	h, err := p.newHandle(metric, p.histogramLabels[metric], labelNames)
+	if err != nil {
+		return nil, err
+	}
+	return &promObserver{promHandle: h, vec: vec}, nil
+}
+
+// statsdHandle pre-encodes the tags of every bound instrument so reports only
+// pass the ready tag slice to the client
+type statsdHandle struct {
+	s          *StatsdReporter
+	metric     string
+	labelNames []string
+}
+
+// tags encodes labelValues, counting them in LabelMismatch and returning
+// false when they do not match the label names
+func (h *statsdHandle) tags(labelValues []string) ([]string, bool) {
+	if len(labelValues) != len(h.labelNames) {
+		h.s.ReportCount(LabelMismatch, map[string]string{"metric": h.metric, "reason": "arity"}, 1)
+		return nil, false
+	}
+	tags := make([]string, len(h.s.defaultTags), len(h.s.defaultTags)+len(h.labelNames))
+	copy(tags, h.s.defaultTags)
+	for i, name := range h.labelNames {
+		tags = append(tags, name+":"+labelValues[i])
+	}
+	return tags, true
+}
+
+type statsdCounter struct{ *statsdHandle }
+
+func (c statsdCounter) With(labelValues ...string) BoundCounter {
+	tags, ok := c.tags(labelValues)
+	if !ok {
+		return noopBound{}
+	}
+	return &statsdBound{s: c.s, metric: c.metric, tags: tags}
+}
+
+type statsdGauge struct{ *statsdHandle }
+
+func (g statsdGauge) With(labelValues ...string) BoundGauge {
+	tags, ok := g.tags(labelValues)
+	if !ok {
+		return noopBound{}
+	}
+	return &statsdBound{s: g.s, metric: g.metric, tags: tags}
+}
+
+type statsdTimer struct{ *statsdHandle }
+
+func (t statsdTimer) With(labelValues ...string) BoundTimer {
+	tags, ok := t.tags(labelValues)
+	if !ok {
+		return noopTimer{}
+	}
+	return &statsdBoundTimer{statsdBound{s: t.s, metric: t.metric, tags: tags}}
+}
+
+type statsdHistogram struct{ *statsdHandle }
+
+func (h statsdHistogram) With(labelValues ...string) BoundHistogram {
+	tags, ok := h.tags(labelValues)
+	if !ok {
+		return noopBound{}
+	}
+	return &statsdBound{s: h.s, metric: h.metric, tags: tags}
+}
+
+type statsdBound struct {
+	s      *StatsdReporter
+	metric string
+	tags   []string
+}
+
+func (b *statsdBound) Add(delta float64) {
+	if err := b.s.client.Count(b.metric, int64(delta), b.tags, b.s.rate); err != nil {
+		b.s.logError("count", err)
+	}
+}
+
+func (b *statsdBound) Set(value float64) {
+	if err := b.s.client.Gauge(b.metric, value, b.tags, b.s.rate); err != nil {
+		b.s.logError("gauge", err)
+	}
+}
+
+func (b *statsdBound) Observe(value float64) {
+	if !b.s.legacyUnits {
+		value = convertUnit(value, MetricUnit(b.metric), UnitMilliseconds)
+	}
+	var err error
+	switch b.s.histogramKind {
+	case ServerDistribution:
+		err = b.s.client.Distribution(b.metric, value, b.tags, b.s.rate)
+	default:
+		err = b.s.client.Histogram(b.metric, value, b.tags, b.s.rate)
+	}
+	if err != nil {
+		b.s.logError("histogram", err)
+	}
+}
+
+type statsdBoundTimer struct {
+	statsdBound
+}
+
+func (t *statsdBoundTimer) Observe(d time.Duration) {
+	value := float64(d.Nanoseconds())
+	if !t.s.legacyUnits {
+		value = float64(d) / float64(time.Millisecond)
+	}
+	if err := t.s.client.TimeInMilliseconds(t.metric, value, t.tags, t.s.rate); err != nil {
+		t.s.logError("summary", err)
+	}
+}
+
+// Counter returns a handle reporting metric as a statsd count
+func (s *StatsdReporter) Counter(metric string, labelNames ...string) (Counter, error) {
+	return statsdCounter{&statsdHandle{s: s, metric: metric, labelNames: labelNames}}, nil
+}
+
+// Gauge returns a handle reporting metric as a statsd gauge
+func (s *StatsdReporter) Gauge(metric string, labelNames ...string) (Gauge, error) {
+	return statsdGauge{&statsdHandle{s: s, metric: metric, labelNames: labelNames}}, nil
+}
+
+// Timer returns a handle reporting metric as a statsd timer, durations are
+// sent in milliseconds, or nanoseconds with legacy units
+func (s *StatsdReporter) Timer(metric string, labelNames ...string) (Timer, error) {
+	return statsdTimer{&statsdHandle{s: s, metric: metric, labelNames: labelNames}}, nil
+}
+
+// Histogram returns a handle reporting metric as ReportHistogram does
+func (s *StatsdReporter) Histogram(metric string, labelNames ...string) (Histogram, error) {
+	return statsdHistogram{&statsdHandle{s: s, metric: metric, labelNames: labelNames}}, nil
+}
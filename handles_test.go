@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type noopClient struct{}
+
+func (noopClient) Count(string, int64, []string, float64) error                { return nil }
+func (noopClient) Gauge(string, float64, []string, float64) error              { return nil }
+func (noopClient) TimeInMilliseconds(string, float64, []string, float64) error { return nil }
+func (noopClient) Histogram(string, float64, []string, float64) error          { return nil }
+func (noopClient) Distribution(string, float64, []string, float64) error       { return nil }
+
+func TestStatsdHandles(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockClient := mocks.NewMockClient(ctrl)
+
+	sr, err := NewStatsdReporter(config.Metrics{GoTechBookFrameworkMetricsStatsdRate: 1}, "game", mockClient)
+	assert.NoError(t, err)
+
+	counter, err := sr.Counter(ExceededRateLimiting, "route")
+	assert.NoError(t, err)
+	gauge, err := sr.Gauge(ConnectedClients)
+	assert.NoError(t, err)
+	timer, err := sr.Timer(ResponseTime, "route", "status")
+	assert.NoError(t, err)
+
+	mockClient.EXPECT().Count(ExceededRateLimiting, int64(2), []string{"serverType:game", "route:room.join"}, float64(1))
+	mockClient.EXPECT().Gauge(ConnectedClients, float64(7), []string{"serverType:game"}, float64(1))
+	mockClient.EXPECT().TimeInMilliseconds(ResponseTime, gomock.Any(), []string{"serverType:game", "route:room.join", "status:ok"}, float64(1))
+
+	counter.With("room.join").Add(2)
+	gauge.With().Set(7)
+	timer.With("room.join", "ok").Observe(time.Millisecond)
+
+	// a missing label value is counted and reports nothing
+	mockClient.EXPECT().Count(LabelMismatch, int64(1), gomock.Any(), float64(1))
+	timer.With("room.join").Observe(time.Millisecond)
+
+	bound := counter.With("room.join")
+	sr.client = noopClient{}
+	assert.Equal(t, float64(0), testing.AllocsPerRun(100, func() {
+		bound.Add(1)
+	}))
+}
+
+func TestPrometheusHandles(t *testing.T) {
+	p, _ := newTestPrometheusReporter(t, nil)
+
+	counter, err := p.Counter(MalformedContext, "key")
+	assert.NoError(t, err)
+	bound := counter.With(StartTimeKey)
+	bound.Add(1)
+	bound.Add(2)
+	assert.Equal(t, float64(3), testutil.ToFloat64(p.countReportersMap[MalformedContext].WithLabelValues(StartTimeKey)))
+
+	timer, err := p.Timer(ResponseTime, "route", "status", "type", "code")
+	assert.NoError(t, err)
+	timer.With("room.join", "ok", "handler", "").Observe(time.Millisecond)
+	assert.Equal(t, 1, testutil.CollectAndCount(p.summaryReportersMap[ResponseTime]))
+
+	_, err = p.Gauge(CountServers, "zone")
+	assert.True(t, errors.Is(err, ErrLabelMismatch))
+	_, err = p.Histogram("unknown")
+	assert.Equal(t, ErrMetricNotKnown, err)
+
+	gauge, err := p.Gauge(CountServers, "type")
+	assert.NoError(t, err)
+	gauge.With("connector", "extra").Set(1)
+	assert.Equal(t, float64(1), testutil.ToFloat64(p.countReportersMap[LabelMismatch].WithLabelValues(CountServers, "arity")))
+}
+
+func BenchmarkStatsdReportCount(b *testing.B) {
+	sr, _ := NewStatsdReporter(config.Metrics{GoTechBookFrameworkMetricsStatsdRate: 1}, "game", noopClient{})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		sr.ReportCount(ExceededRateLimiting, map[string]string{"route": "room.join"}, 1)
+	}
+}
+
+func BenchmarkStatsdBoundCounter(b *testing.B) {
+	sr, _ := NewStatsdReporter(config.Metrics{GoTechBookFrameworkMetricsStatsdRate: 1}, "game", noopClient{})
+	counter, _ := sr.Counter(ExceededRateLimiting, "route")
+	bound := counter.With("room.join")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bound.Add(1)
+	}
+}
+
+func BenchmarkPrometheusReportCount(b *testing.B) {
+	p, err := NewPrometheusReporter("game", config.Metrics{}, nil, WithRegistry(prometheus.NewRegistry()), WithoutServer())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.ReportCount(MalformedContext, map[string]string{"key": StartTimeKey}, 1)
+	}
+}
+
+func BenchmarkPrometheusBoundCounter(b *testing.B) {
+	p, err := NewPrometheusReporter("game", config.Metrics{}, nil, WithRegistry(prometheus.NewRegistry()), WithoutServer())
+	if err != nil {
+		b.Fatal(err)
+	}
+	counter, _ := p.Counter(MalformedContext, "key")
+	bound := counter.With(StartTimeKey)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bound.Add(1)
+	}
+}
@@ -0,0 +1,369 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	logger "github.com/gotechbook/gotechbook-framework-logger"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// influxUDPPayloadSize bounds the datagrams InfluxReporter sends over UDP, lines
+// are never split so a single longer line is sent alone
+const influxUDPPayloadSize = 1400
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// InfluxReporter is a Reporter writing every report as an InfluxDB line
+// protocol point with a single "value" field, batching the lines until the
+// batch is full or the flush interval elapses. Batches are written by a
+// background goroutine, reports never wait for the server
+type InfluxReporter struct {
+	addr        string
+	udp         bool
+	database    string
+	client      *http.Client
+	batchSize   int
+	interval    time.Duration
+	retries     int
+	backoff     time.Duration
+	defaultTags map[string]string
+
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	lines int
+	// full holds the batches filled since the last flush, in order
+	full [][]byte
+	// sendMu serializes the writes so batches reach the server in order
+	sendMu sync.Mutex
+	conn   net.Conn
+
+	// wake asks the loop to write the full batches
+	wake chan struct{}
+	// ctx is canceled when Shutdown gives up waiting for the loop, aborting
+	// its retries
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// InfluxOption configures an InfluxReporter
+type InfluxOption func(*InfluxReporter)
+
+// WithInfluxUDP writes the batches as UDP datagrams to addr, a host:port,
+// instead of POSTing them to the /write endpoint of addr
+func WithInfluxUDP() InfluxOption {
+	return func(i *InfluxReporter) {
+		i.udp = true
+	}
+}
+
+// WithInfluxDatabase sets the db parameter of the HTTP writes
+func WithInfluxDatabase(database string) InfluxOption {
+	return func(i *InfluxReporter) {
+		i.database = database
+	}
+}
+
+// WithInfluxHTTPClient makes the reporter write with client instead of a
+// client timing out after 10s
+func WithInfluxHTTPClient(client *http.Client) InfluxOption {
+	return func(i *InfluxReporter) {
+		i.client = client
+	}
+}
+
+// WithInfluxBatchSize sets how many lines are buffered before they are
+// written, 500 by default
+func WithInfluxBatchSize(size int) InfluxOption {
+	return func(i *InfluxReporter) {
+		if size > 0 {
+			i.batchSize = size
+		}
+	}
+}
+
+// WithInfluxFlushInterval sets how often the buffered lines are written, 10s
+// by default
+func WithInfluxFlushInterval(interval time.Duration) InfluxOption {
+	return func(i *InfluxReporter) {
+		if interval > 0 {
+			i.interval = interval
+		}
+	}
+}
+
+// WithInfluxRetry sets how many times a failed HTTP write is retried and the
+// delay before the first retry, doubled on every attempt. The default is 3
+// retries starting at 100ms
+func WithInfluxRetry(retries int, backoff time.Duration) InfluxOption {
+	return func(i *InfluxReporter) {
+		i.retries = retries
+		i.backoff = backoff
+	}
+}
+
+// NewInfluxReporter returns an InfluxReporter writing to addr, the base URL of
+// the InfluxDB HTTP API or a host:port with WithInfluxUDP. Every point is
+// tagged with serverType and GoTechBookFrameworkMetricsConstTags
+func NewInfluxReporter(metrics config.Metrics, serverType string, addr string, opts ...InfluxOption) (*InfluxReporter, error) {
+	i := &InfluxReporter{
+		addr:        addr,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		batchSize:   500,
+		interval:    10 * time.Second,
+		retries:     3,
+		backoff:     100 * time.Millisecond,
+		defaultTags: map[string]string{"serverType": serverType},
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	i.ctx, i.cancel = context.WithCancel(context.Background())
+	for k, v := range metrics.GoTechBookFrameworkMetricsConstTags {
+		i.defaultTags[k] = v
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+
+	if i.udp {
+		conn, err := net.Dial("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		i.conn = conn
+	} else if _, err := url.Parse(addr); err != nil {
+		return nil, err
+	}
+
+	go i.loop()
+	return i, nil
+}
+
+func (i *InfluxReporter) loop() {
+	defer close(i.done)
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-i.stop:
+			return
+		case <-ticker.C:
+		case <-i.wake:
+		}
+		if err := i.flush(i.ctx); err != nil {
+			logger.Log.Errorf("failed to write influx batch: %q", err)
+		}
+	}
+}
+
+func (i *InfluxReporter) ReportCount(metric string, tags map[string]string, count float64) error {
+	return i.write(metric, tags, count)
+}
+
+func (i *InfluxReporter) ReportSummary(metric string, tags map[string]string, value float64) error {
+	return i.write(metric, tags, value)
+}
+
+func (i *InfluxReporter) ReportHistogram(metric string, tags map[string]string, value float64) error {
+	return i.write(metric, tags, value)
+}
+
+func (i *InfluxReporter) ReportGauge(metric string, tags map[string]string, value float64) error {
+	return i.write(metric, tags, value)
+}
+
+func (i *InfluxReporter) write(metric string, tags map[string]string, value float64) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	appendInfluxLine(&i.buf, metric, i.defaultTags, tags, value, time.Now())
+	i.lines++
+	if i.lines < i.batchSize {
+		return nil
+	}
+	i.full = append(i.full, i.take())
+	select {
+	case i.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// take returns a copy of the buffered lines and empties the buffer
+func (i *InfluxReporter) take() []byte {
+	batch := make([]byte, i.buf.Len())
+	copy(batch, i.buf.Bytes())
+	i.buf.Reset()
+	i.lines = 0
+	return batch
+}
+
+// appendInfluxLine encodes a point with its tags sorted by key, tags taking
+// precedence over defaultTags
+func appendInfluxLine(buf *bytes.Buffer, metric string, defaultTags, tags map[string]string, value float64, ts time.Time) {
+	keys := make([]string, 0, len(defaultTags)+len(tags))
+	for k := range defaultTags {
+		if _, ok := tags[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf.WriteString(influxMeasurementEscaper.Replace(metric))
+	for _, k := range keys {
+		v, ok := tags[k]
+		if !ok {
+			v = defaultTags[k]
+		}
+		if v == "" {
+			// line protocol has no empty tag values
+			continue
+		}
+		buf.WriteByte(',')
+		buf.WriteString(influxTagEscaper.Replace(k))
+		buf.WriteByte('=')
+		buf.WriteString(influxTagEscaper.Replace(v))
+	}
+	buf.WriteString(" value=")
+	buf.WriteString(strconv.FormatFloat(value, 'f', -1, 64))
+	buf.WriteByte(' ')
+	buf.WriteString(strconv.FormatInt(ts.UnixNano(), 10))
+	buf.WriteByte('\n')
+}
+
+// Flush writes the full batches and the buffered lines
+func (i *InfluxReporter) Flush() error {
+	return i.flush(context.Background())
+}
+
+func (i *InfluxReporter) flush(ctx context.Context) error {
+	i.mu.Lock()
+	batches := i.full
+	i.full = nil
+	if i.lines > 0 {
+		batches = append(batches, i.take())
+	}
+	i.mu.Unlock()
+
+	i.sendMu.Lock()
+	defer i.sendMu.Unlock()
+	var errs []error
+	for _, batch := range batches {
+		if i.udp {
+			errs = append(errs, i.sendUDP(batch))
+		} else {
+			errs = append(errs, i.sendHTTP(ctx, batch))
+		}
+	}
+	return joinErrors(errs...)
+}
+
+func (i *InfluxReporter) sendUDP(batch []byte) error {
+	for len(batch) > 0 {
+		n := len(batch)
+		if n > influxUDPPayloadSize {
+			n = bytes.LastIndexByte(batch[:influxUDPPayloadSize], '\n') + 1
+			if n == 0 {
+				n = bytes.IndexByte(batch, '\n') + 1
+			}
+		}
+		if _, err := i.conn.Write(batch[:n]); err != nil {
+			return err
+		}
+		batch = batch[n:]
+	}
+	return nil
+}
+
+func (i *InfluxReporter) sendHTTP(ctx context.Context, batch []byte) error {
+	query := url.Values{"precision": {"ns"}}
+	if i.database != "" {
+		query.Set("db", i.database)
+	}
+	endpoint := strings.TrimSuffix(i.addr, "/") + "/write?" + query.Encode()
+
+	backoff := i.backoff
+	var err error
+	for attempt := 0; attempt <= i.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+		var retry bool
+		retry, err = i.post(ctx, endpoint, batch)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post sends batch once, retry tells whether a failure may be transient
+func (i *InfluxReporter) post(ctx context.Context, endpoint string, batch []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(batch))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	res, err := i.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("influx write failed with status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Shutdown stops the flush loop and writes the buffered lines
+func (i *InfluxReporter) Shutdown(ctx context.Context) error {
+	i.once.Do(func() {
+		close(i.stop)
+	})
+	select {
+	case <-i.done:
+	case <-ctx.Done():
+		i.cancel()
+		return ctx.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := i.flush(ctx)
+		if i.conn != nil {
+			err = joinErrors(err, i.conn.Close())
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
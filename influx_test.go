@@ -0,0 +1,148 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAppendInfluxLine(t *testing.T) {
+	var buf bytes.Buffer
+	appendInfluxLine(&buf, "response time",
+		map[string]string{"serverType": "game", "region": "us east", "route": "default"},
+		map[string]string{"route": "room,join", "code": "", "a=b": "c"},
+		1.5, time.Unix(0, 42))
+	assert.Equal(t, `response\ time,a\=b=c,region=us\ east,route=room\,join,serverType=game value=1.5 42`+"\n", buf.String())
+}
+
+func TestInfluxReporterHTTP(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		bodies   []string
+		failures = 1
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/write", r.URL.Path)
+		assert.Equal(t, "metrics", r.URL.Query().Get("db"))
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	i, err := NewInfluxReporter(config.Metrics{
+		GoTechBookFrameworkMetricsConstTags: map[string]string{"region": "us-east"},
+	}, "game", server.URL, WithInfluxDatabase("metrics"), WithInfluxBatchSize(2), WithInfluxRetry(2, time.Millisecond))
+	assert.NoError(t, err)
+
+	assert.NoError(t, i.ReportCount(ExceededRateLimiting, map[string]string{}, 1))
+	assert.NoError(t, i.ReportGauge(ConnectedClients, map[string]string{}, 3))
+	assert.NoError(t, i.ReportSummary(ResponseTime, map[string]string{"route": "room.join"}, 7))
+	assert.NoError(t, i.Shutdown(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if assert.Len(t, bodies, 2) {
+		lines := strings.Split(strings.TrimSpace(bodies[0]), "\n")
+		assert.Len(t, lines, 2)
+		assert.True(t, strings.HasPrefix(lines[0], ExceededRateLimiting+",region=us-east,serverType=game value=1 "))
+		assert.True(t, strings.HasPrefix(lines[1], ConnectedClients+",region=us-east,serverType=game value=3 "))
+		assert.True(t, strings.HasPrefix(bodies[1], ResponseTime+",region=us-east,route=room.join,serverType=game value=7 "))
+	}
+}
+
+func TestInfluxReporterHTTPClientError(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		http.Error(w, "unable to parse", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	i, err := NewInfluxReporter(config.Metrics{}, "game", server.URL, WithInfluxRetry(3, time.Millisecond))
+	assert.NoError(t, err)
+	assert.NoError(t, i.ReportCount(ExceededRateLimiting, map[string]string{}, 1))
+	err = i.Flush()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to parse")
+	assert.Equal(t, 1, calls)
+	assert.NoError(t, i.Shutdown(context.Background()))
+}
+
+func TestInfluxReporterUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	i, err := NewInfluxReporter(config.Metrics{}, "game", conn.LocalAddr().String(), WithInfluxUDP())
+	assert.NoError(t, err)
+	tags := map[string]string{"route": strings.Repeat("r", 100)}
+	for n := 0; n < 20; n++ {
+		assert.NoError(t, i.ReportCount(ExceededRateLimiting, tags, 1))
+	}
+	assert.NoError(t, i.Shutdown(context.Background()))
+
+	var lines int
+	payload := make([]byte, 65536)
+	for lines < 20 {
+		assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+		n, _, err := conn.ReadFrom(payload)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.LessOrEqual(t, n, influxUDPPayloadSize)
+		assert.Equal(t, byte('\n'), payload[n-1])
+		lines += bytes.Count(payload[:n], []byte("\n"))
+	}
+	assert.Equal(t, 20, lines)
+}
+
+func TestInfluxReporterFullBatchDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	i, err := NewInfluxReporter(config.Metrics{}, "game", server.URL, WithInfluxBatchSize(1), WithInfluxFlushInterval(0))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 10*time.Second, i.interval)
+
+	reported := make(chan struct{})
+	go func() {
+		for n := 0; n < 3; n++ {
+			i.ReportCount(ExceededRateLimiting, map[string]string{}, 1)
+		}
+		close(reported)
+	}()
+	select {
+	case <-reported:
+	case <-time.After(time.Second):
+		t.Fatal("reports waited for the server")
+	}
+	close(release)
+	assert.NoError(t, i.Shutdown(context.Background()))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
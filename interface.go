@@ -1,5 +1,10 @@
 package metrics
 
+import (
+	"context"
+	config "github.com/gotechbook/gotechbook-framework-config"
+)
+
 type Reporter interface {
 	ReportCount(metric string, tags map[string]string, count float64) error
 	ReportSummary(metric string, tags map[string]string, value float64) error
@@ -11,4 +16,35 @@ type Client interface {
 	Count(name string, value int64, tags []string, rate float64) error
 	Gauge(name string, value float64, tags []string, rate float64) error
 	TimeInMilliseconds(name string, value float64, tags []string, rate float64) error
+	Histogram(name string, value float64, tags []string, rate float64) error
+	Distribution(name string, value float64, tags []string, rate float64) error
+}
+
+// Flusher is implemented by reporters buffering samples before sending them
+type Flusher interface {
+	Flush() error
+}
+
+// Closer is implemented by reporters owning connections or background
+// goroutines, Shutdown flushes pending data and releases them before ctx expires
+type Closer interface {
+	Shutdown(ctx context.Context) error
+}
+
+// WeightedReporter is implemented by reporters able to send a value observed
+// count times in a single call, the AggregatingReporter flushes its sketches
+// through it
+type WeightedReporter interface {
+	ReportSummaryWeighted(metric string, tags map[string]string, value float64, count uint64) error
+	ReportHistogramWeighted(metric string, tags map[string]string, value float64, count uint64) error
+}
+
+// Registrar is implemented by reporters accepting metric declarations after
+// they were built, for modules loaded once the CustomMetricsSpec was read
+type Registrar interface {
+	RegisterCounter(counter *config.Counter) error
+	RegisterGauge(gauge *config.Gauge) error
+	RegisterSummary(summary *config.Summary) error
+	RegisterHistogram(histogram *config.Histogram) error
+	Unregister(metric string) error
 }
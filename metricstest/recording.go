@@ -0,0 +1,265 @@
+// Package metricstest provides a Reporter recording every sample in memory,
+// with query and assertion helpers to test instrumentation declaratively
+package metricstest
+
+import (
+	"fmt"
+	metrics "github.com/gotechbook/gotechbook-framework-metrics"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kind is the Reporter method a sample was reported with
+type Kind int
+
+const (
+	KindCount Kind = iota
+	KindGauge
+	KindSummary
+	KindHistogram
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindCount:
+		return "count"
+	case KindGauge:
+		return "gauge"
+	case KindSummary:
+		return "summary"
+	case KindHistogram:
+		return "histogram"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Sample is a single report received by a RecordingReporter
+type Sample struct {
+	Kind  Kind
+	Name  string
+	Tags  map[string]string
+	Value float64
+}
+
+func (s Sample) String() string {
+	keys := make([]string, 0, len(s.Tags))
+	for k := range s.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tags := make([]string, len(keys))
+	for i, k := range keys {
+		tags[i] = k + "=" + s.Tags[k]
+	}
+	return fmt.Sprintf("%s %s{%s} %v", s.Kind, s.Name, strings.Join(tags, ","), s.Value)
+}
+
+// TagMatcher selects samples by their tags
+type TagMatcher func(tags map[string]string) bool
+
+// HasTags matches samples carrying every tag of tags, other tags are ignored
+func HasTags(tags map[string]string) TagMatcher {
+	return func(sampleTags map[string]string) bool {
+		for k, v := range tags {
+			if sv, ok := sampleTags[k]; !ok || sv != v {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// HasTag matches samples carrying key with value
+func HasTag(key, value string) TagMatcher {
+	return HasTags(map[string]string{key: value})
+}
+
+// HasTagKey matches samples carrying key, whatever its value
+func HasTagKey(key string) TagMatcher {
+	return func(tags map[string]string) bool {
+		_, ok := tags[key]
+		return ok
+	}
+}
+
+// TestingT is the subset of testing.TB the assertions use
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// RecordingReporter is a metrics.Reporter keeping every sample it receives,
+// it is safe for concurrent use
+type RecordingReporter struct {
+	mu      sync.Mutex
+	samples []Sample
+}
+
+var _ metrics.Reporter = (*RecordingReporter)(nil)
+
+// NewRecordingReporter returns an empty RecordingReporter
+func NewRecordingReporter() *RecordingReporter {
+	return &RecordingReporter{}
+}
+
+func (r *RecordingReporter) record(kind Kind, metric string, tags map[string]string, value float64) error {
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = append(r.samples, Sample{Kind: kind, Name: metric, Tags: copied, Value: value})
+	return nil
+}
+
+func (r *RecordingReporter) ReportCount(metric string, tags map[string]string, count float64) error {
+	return r.record(KindCount, metric, tags, count)
+}
+
+func (r *RecordingReporter) ReportSummary(metric string, tags map[string]string, value float64) error {
+	return r.record(KindSummary, metric, tags, value)
+}
+
+func (r *RecordingReporter) ReportHistogram(metric string, tags map[string]string, value float64) error {
+	return r.record(KindHistogram, metric, tags, value)
+}
+
+func (r *RecordingReporter) ReportGauge(metric string, tags map[string]string, value float64) error {
+	return r.record(KindGauge, metric, tags, value)
+}
+
+// All returns every sample in the order they were reported
+func (r *RecordingReporter) All() []Sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Sample(nil), r.samples...)
+}
+
+// Samples returns the samples of metric matching every matcher, in the
+// order they were reported
+func (r *RecordingReporter) Samples(name string, matchers ...TagMatcher) []Sample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var samples []Sample
+	for _, s := range r.samples {
+		if s.Name == name && matches(s.Tags, matchers) {
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
+
+// Counted returns the sum of the counts reported for metric matching every
+// matcher
+func (r *RecordingReporter) Counted(name string, matchers ...TagMatcher) float64 {
+	var total float64
+	for _, s := range r.Samples(name, matchers...) {
+		if s.Kind == KindCount {
+			total += s.Value
+		}
+	}
+	return total
+}
+
+// LastGauge returns the last value reported for the gauge metric matching
+// every matcher, ok is false when there is none
+func (r *RecordingReporter) LastGauge(name string, matchers ...TagMatcher) (value float64, ok bool) {
+	for _, s := range r.Samples(name, matchers...) {
+		if s.Kind == KindGauge {
+			value, ok = s.Value, true
+		}
+	}
+	return value, ok
+}
+
+// Observed returns the values reported through ReportSummary and
+// ReportHistogram for metric matching every matcher
+func (r *RecordingReporter) Observed(name string, matchers ...TagMatcher) []float64 {
+	var values []float64
+	for _, s := range r.Samples(name, matchers...) {
+		if s.Kind == KindSummary || s.Kind == KindHistogram {
+			values = append(values, s.Value)
+		}
+	}
+	return values
+}
+
+// Reset forgets every sample
+func (r *RecordingReporter) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples = nil
+}
+
+// AssertCounted checks the counts reported for metric with at least tags sum
+// up to n
+func (r *RecordingReporter) AssertCounted(t TestingT, name string, tags map[string]string, n float64) bool {
+	t.Helper()
+	if got := r.Counted(name, HasTags(tags)); got != n {
+		t.Errorf("expected %s%v to be counted %v times, got %v\n%s", name, tags, n, got, r.dump(name))
+		return false
+	}
+	return true
+}
+
+// AssertGauge checks the last gauge reported for metric with at least tags
+// is value
+func (r *RecordingReporter) AssertGauge(t TestingT, name string, tags map[string]string, value float64) bool {
+	t.Helper()
+	got, ok := r.LastGauge(name, HasTags(tags))
+	if !ok {
+		t.Errorf("expected gauge %s%v to be reported\n%s", name, tags, r.dump(name))
+		return false
+	}
+	if got != value {
+		t.Errorf("expected gauge %s%v to be %v, got %v\n%s", name, tags, value, got, r.dump(name))
+		return false
+	}
+	return true
+}
+
+// AssertObserved checks metric with at least tags was observed n times
+// through ReportSummary or ReportHistogram
+func (r *RecordingReporter) AssertObserved(t TestingT, name string, tags map[string]string, n int) bool {
+	t.Helper()
+	if got := len(r.Observed(name, HasTags(tags))); got != n {
+		t.Errorf("expected %s%v to be observed %d times, got %d\n%s", name, tags, n, got, r.dump(name))
+		return false
+	}
+	return true
+}
+
+// AssertNotReported checks nothing was reported for metric with at least tags
+func (r *RecordingReporter) AssertNotReported(t TestingT, name string, tags map[string]string) bool {
+	t.Helper()
+	if samples := r.Samples(name, HasTags(tags)); len(samples) > 0 {
+		t.Errorf("expected %s%v not to be reported\n%s", name, tags, r.dump(name))
+		return false
+	}
+	return true
+}
+
+// dump lists the samples of metric to explain a failed assertion
+func (r *RecordingReporter) dump(name string) string {
+	samples := r.Samples(name)
+	if len(samples) == 0 {
+		return "no samples were reported for " + name
+	}
+	lines := make([]string, len(samples))
+	for i, s := range samples {
+		lines[i] = "\t" + s.String()
+	}
+	return "reported samples:\n" + strings.Join(lines, "\n")
+}
+
+func matches(tags map[string]string, matchers []TagMatcher) bool {
+	for _, m := range matchers {
+		if !m(tags) {
+			return false
+		}
+	}
+	return true
+}
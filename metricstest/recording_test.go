@@ -0,0 +1,111 @@
+package metricstest
+
+import (
+	"context"
+	"fmt"
+	gContext "github.com/gotechbook/gotechbook-framework-context"
+	metrics "github.com/gotechbook/gotechbook-framework-metrics"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeT records the failures of the assertions under test
+type fakeT struct {
+	errors []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...interface{}) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func TestRecordingReporterQueries(t *testing.T) {
+	r := NewRecordingReporter()
+	tags := map[string]string{"route": "room.join", "status": "ok"}
+	r.ReportCount(metrics.ExceededRateLimiting, tags, 1)
+	r.ReportCount(metrics.ExceededRateLimiting, map[string]string{"route": "room.leave"}, 2)
+	r.ReportGauge(metrics.ConnectedClients, map[string]string{}, 3)
+	r.ReportGauge(metrics.ConnectedClients, map[string]string{}, 4)
+	r.ReportSummary(metrics.ResponseTime, tags, 10)
+	r.ReportHistogram(metrics.ResponseTime, tags, 20)
+	tags["route"] = "mutated"
+
+	assert.Len(t, r.All(), 6)
+	assert.Equal(t, float64(3), r.Counted(metrics.ExceededRateLimiting))
+	assert.Equal(t, float64(1), r.Counted(metrics.ExceededRateLimiting, HasTag("route", "room.join")))
+	assert.Equal(t, float64(0), r.Counted(metrics.ExceededRateLimiting, HasTagKey("status"), HasTag("route", "room.leave")))
+	value, ok := r.LastGauge(metrics.ConnectedClients)
+	assert.True(t, ok)
+	assert.Equal(t, float64(4), value)
+	_, ok = r.LastGauge(metrics.ConnectedClients, HasTagKey("route"))
+	assert.False(t, ok)
+	assert.Equal(t, []float64{10, 20}, r.Observed(metrics.ResponseTime, HasTags(map[string]string{"route": "room.join"})))
+
+	samples := r.Samples(metrics.ResponseTime)
+	if assert.Len(t, samples, 2) {
+		assert.Equal(t, KindSummary, samples[0].Kind)
+		assert.Equal(t, "histogram response_time_ns{route=room.join,status=ok} 20", samples[1].String())
+	}
+
+	r.Reset()
+	assert.Empty(t, r.All())
+}
+
+func TestRecordingReporterAssertions(t *testing.T) {
+	r := NewRecordingReporter()
+	r.ReportCount(metrics.ExceededRateLimiting, map[string]string{"route": "room.join"}, 2)
+	r.ReportGauge(metrics.ConnectedClients, map[string]string{"region": "eu"}, 5)
+	r.ReportSummary(metrics.ProcessDelay, map[string]string{"route": "room.join"}, 1)
+
+	passing := &fakeT{}
+	assert.True(t, r.AssertCounted(passing, metrics.ExceededRateLimiting, map[string]string{}, 2))
+	assert.True(t, r.AssertGauge(passing, metrics.ConnectedClients, map[string]string{"region": "eu"}, 5))
+	assert.True(t, r.AssertObserved(passing, metrics.ProcessDelay, map[string]string{"route": "room.join"}, 1))
+	assert.True(t, r.AssertNotReported(passing, metrics.ResponseTime, nil))
+	assert.Empty(t, passing.errors)
+
+	failing := &fakeT{}
+	assert.False(t, r.AssertCounted(failing, metrics.ExceededRateLimiting, map[string]string{"route": "room.join"}, 1))
+	assert.False(t, r.AssertGauge(failing, metrics.ConnectedClients, map[string]string{"region": "us"}, 5))
+	assert.False(t, r.AssertObserved(failing, metrics.ProcessDelay, nil, 2))
+	assert.False(t, r.AssertNotReported(failing, metrics.ExceededRateLimiting, nil))
+	if assert.Len(t, failing.errors, 4) {
+		assert.Contains(t, failing.errors[0], "count exceeded_rate_limiting{route=room.join} 2")
+		assert.Contains(t, failing.errors[1], "expected gauge connected_clients")
+	}
+}
+
+func TestRecordingReporterWithHelpers(t *testing.T) {
+	r := NewRecordingReporter()
+	reporters := []metrics.Reporter{r}
+	assert.NoError(t, metrics.ReportNumberOfConnectedClients(reporters, 42))
+	assert.NoError(t, metrics.ReportExceededRateLimiting(reporters))
+	ctx := gContext.AddToPropagateCtx(context.Background(), metrics.StartTimeKey, time.Now().UnixNano())
+	ctx = gContext.AddToPropagateCtx(ctx, metrics.RouteKey, "room.join")
+	assert.NoError(t, metrics.ReportTimingFromCtx(ctx, reporters, "handler", nil))
+
+	r.AssertGauge(t, metrics.ConnectedClients, nil, 42)
+	r.AssertCounted(t, metrics.ExceededRateLimiting, nil, 1)
+	r.AssertObserved(t, metrics.ResponseTime, map[string]string{"route": "room.join", "status": "ok"}, 1)
+	r.AssertNotReported(t, metrics.MalformedContext, nil)
+}
+
+func TestRecordingReporterConcurrency(t *testing.T) {
+	r := NewRecordingReporter()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.ReportCount(metrics.ExceededRateLimiting, map[string]string{"route": "room.join"}, 1)
+				r.Counted(metrics.ExceededRateLimiting)
+			}
+		}()
+	}
+	wg.Wait()
+	r.AssertCounted(t, metrics.ExceededRateLimiting, map[string]string{"route": "room.join"}, 800)
+}
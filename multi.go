@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// BackendError identifies the MultiReporter backend a failure comes from
+type BackendError struct {
+	Backend string
+	Err     error
+}
+
+func (e *BackendError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Backend, e.Err)
+}
+
+func (e *BackendError) Unwrap() error {
+	return e.Err
+}
+
+// MultiReporter is a Reporter fanning out every report to several backends
+type MultiReporter struct {
+	names     []string
+	reporters []Reporter
+	failures  []uint64
+	parallel  bool
+}
+
+// MultiReporterOption configures a MultiReporter
+type MultiReporterOption func(*MultiReporter)
+
+// WithParallelFanOut reports to every backend on its own goroutine, each one
+// receiving its own copy of the tags
+func WithParallelFanOut() MultiReporterOption {
+	return func(m *MultiReporter) {
+		m.parallel = true
+	}
+}
+
+// NewMultiReporter returns a MultiReporter over backends, keyed by the name
+// used in errors and failure counts
+func NewMultiReporter(backends map[string]Reporter, opts ...MultiReporterOption) *MultiReporter {
+	m := &MultiReporter{
+		names:     make([]string, 0, len(backends)),
+		reporters: make([]Reporter, 0, len(backends)),
+		failures:  make([]uint64, len(backends)),
+	}
+	for name := range backends {
+		m.names = append(m.names, name)
+	}
+	sort.Strings(m.names)
+	for _, name := range m.names {
+		m.reporters = append(m.reporters, backends[name])
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Failures returns how many reports failed on each backend
+func (m *MultiReporter) Failures() map[string]uint64 {
+	failures := make(map[string]uint64, len(m.names))
+	for i, name := range m.names {
+		failures[name] = atomic.LoadUint64(&m.failures[i])
+	}
+	return failures
+}
+
+func (m *MultiReporter) fanOut(tags map[string]string, report func(r Reporter, tags map[string]string) error) error {
+	errs := make([]error, len(m.reporters))
+	if m.parallel {
+		var wg sync.WaitGroup
+		wg.Add(len(m.reporters))
+		for i, r := range m.reporters {
+			go func(i int, r Reporter, tags map[string]string) {
+				defer wg.Done()
+				errs[i] = report(r, tags)
+			}(i, r, copyTags(tags))
+		}
+		wg.Wait()
+	} else {
+		for i, r := range m.reporters {
+			errs[i] = report(r, tags)
+		}
+	}
+
+	for i, err := range errs {
+		if err != nil {
+			atomic.AddUint64(&m.failures[i], 1)
+			errs[i] = &BackendError{Backend: m.names[i], Err: err}
+		}
+	}
+	return joinErrors(errs...)
+}
+
+func (m *MultiReporter) ReportCount(metric string, tags map[string]string, count float64) error {
+	return m.fanOut(tags, func(r Reporter, tags map[string]string) error {
+		return r.ReportCount(metric, tags, count)
+	})
+}
+
+func (m *MultiReporter) ReportSummary(metric string, tags map[string]string, value float64) error {
+	return m.fanOut(tags, func(r Reporter, tags map[string]string) error {
+		return r.ReportSummary(metric, tags, value)
+	})
+}
+
+func (m *MultiReporter) ReportHistogram(metric string, tags map[string]string, value float64) error {
+	return m.fanOut(tags, func(r Reporter, tags map[string]string) error {
+		return r.ReportHistogram(metric, tags, value)
+	})
+}
+
+// ReportSummaryWeighted sends value observed count times to every backend,
+// one call per observation for the backends not implementing WeightedReporter
+func (m *MultiReporter) ReportSummaryWeighted(metric string, tags map[string]string, value float64, count uint64) error {
+	return m.fanOut(tags, func(r Reporter, tags map[string]string) error {
+		return reportWeighted(r, summarySample, metric, tags, value, count)
+	})
+}
+
+// ReportHistogramWeighted is ReportSummaryWeighted for histograms
+func (m *MultiReporter) ReportHistogramWeighted(metric string, tags map[string]string, value float64, count uint64) error {
+	return m.fanOut(tags, func(r Reporter, tags map[string]string) error {
+		return reportWeighted(r, histogramSample, metric, tags, value, count)
+	})
+}
+
+func (m *MultiReporter) ReportGauge(metric string, tags map[string]string, value float64) error {
+	return m.fanOut(tags, func(r Reporter, tags map[string]string) error {
+		return r.ReportGauge(metric, tags, value)
+	})
+}
+
+// Flush flushes every backend implementing Flusher
+func (m *MultiReporter) Flush() error {
+	return m.fanOut(nil, func(r Reporter, _ map[string]string) error {
+		if f, ok := r.(Flusher); ok {
+			return f.Flush()
+		}
+		return nil
+	})
+}
+
+// Shutdown shuts down every backend as ShutdownReporters does
+func (m *MultiReporter) Shutdown(ctx context.Context) error {
+	return m.fanOut(nil, func(r Reporter, _ map[string]string) error {
+		return shutdownReporter(ctx, r)
+	})
+}
+
+func (m *MultiReporter) register(register func(r Registrar) error) error {
+	return m.fanOut(nil, func(r Reporter, _ map[string]string) error {
+		return forwardRegistration(r, register)
+	})
+}
+
+// RegisterCounter declares counter on every backend implementing Registrar
+func (m *MultiReporter) RegisterCounter(counter *config.Counter) error {
+	return m.register(func(r Registrar) error { return r.RegisterCounter(counter) })
+}
+
+// RegisterGauge declares gauge on every backend implementing Registrar
+func (m *MultiReporter) RegisterGauge(gauge *config.Gauge) error {
+	return m.register(func(r Registrar) error { return r.RegisterGauge(gauge) })
+}
+
+// RegisterSummary declares summary on every backend implementing Registrar
+func (m *MultiReporter) RegisterSummary(summary *config.Summary) error {
+	return m.register(func(r Registrar) error { return r.RegisterSummary(summary) })
+}
+
+// RegisterHistogram declares histogram on every backend implementing Registrar
+func (m *MultiReporter) RegisterHistogram(histogram *config.Histogram) error {
+	return m.register(func(r Registrar) error { return r.RegisterHistogram(histogram) })
+}
+
+// Unregister removes metric from every backend implementing Registrar
+func (m *MultiReporter) Unregister(metric string) error {
+	return m.register(func(r Registrar) error { return r.Unregister(metric) })
+}
+
+// forwardRegistration calls register on r when it implements Registrar,
+// reporters without declarations accept every metric
+func forwardRegistration(r Reporter, register func(r Registrar) error) error {
+	if registrar, ok := r.(Registrar); ok {
+		return register(registrar)
+	}
+	return nil
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	if tags == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(tags))
+	for k, v := range tags {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/golang/mock/gomock"
+	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMultiReporter(t *testing.T) {
+	for _, parallel := range []bool{false, true} {
+		ctrl := gomock.NewController(t)
+		prometheusReporter := mocks.NewMockReporter(ctrl)
+		statsdReporter := mocks.NewMockReporter(ctrl)
+
+		var opts []MultiReporterOption
+		if parallel {
+			opts = append(opts, WithParallelFanOut())
+		}
+		m := NewMultiReporter(map[string]Reporter{
+			"prometheus": prometheusReporter,
+			"statsd":     statsdReporter,
+		}, opts...)
+
+		tags := map[string]string{"route": "room.join"}
+		statsdErr := errors.New("write: connection refused")
+		prometheusReporter.EXPECT().ReportCount(ExceededRateLimiting, tags, float64(1)).Times(2)
+		statsdReporter.EXPECT().ReportCount(ExceededRateLimiting, tags, float64(1)).Return(statsdErr).Times(2)
+		prometheusReporter.EXPECT().ReportGauge(ConnectedClients, tags, float64(3))
+		statsdReporter.EXPECT().ReportGauge(ConnectedClients, tags, float64(3))
+
+		err := m.ReportCount(ExceededRateLimiting, tags, 1)
+		assert.True(t, errors.Is(err, statsdErr))
+		var backendErr *BackendError
+		assert.True(t, errors.As(err, &backendErr))
+		assert.Equal(t, "statsd", backendErr.Backend)
+		assert.EqualError(t, err, "statsd: write: connection refused")
+
+		assert.Error(t, m.ReportCount(ExceededRateLimiting, tags, 1))
+		assert.NoError(t, m.ReportGauge(ConnectedClients, tags, 3))
+		assert.Equal(t, map[string]uint64{"prometheus": 0, "statsd": 2}, m.Failures())
+
+		prometheusErr := errors.New("collector is not registered")
+		prometheusReporter.EXPECT().ReportSummary(ResponseTime, tags, float64(1)).Return(prometheusErr)
+		statsdReporter.EXPECT().ReportSummary(ResponseTime, tags, float64(1)).Return(statsdErr)
+		err = m.ReportSummary(ResponseTime, tags, 1)
+		assert.True(t, errors.Is(err, prometheusErr))
+		assert.True(t, errors.Is(err, statsdErr))
+		if assert.True(t, errors.As(err, &backendErr)) {
+			assert.Equal(t, "prometheus", backendErr.Backend)
+		}
+		ctrl.Finish()
+	}
+}
+
+func TestReportHelpersReturnErrors(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	first := mocks.NewMockReporter(ctrl)
+	second := mocks.NewMockReporter(ctrl)
+
+	firstErr := errors.New("first")
+	secondErr := errors.New("second")
+	first.EXPECT().ReportGauge(ConnectedClients, map[string]string{}, float64(10)).Return(firstErr)
+	second.EXPECT().ReportGauge(ConnectedClients, map[string]string{}, float64(10)).Return(secondErr)
+
+	err := ReportNumberOfConnectedClients([]Reporter{first, second}, 10)
+	assert.True(t, errors.Is(err, firstErr))
+	assert.True(t, errors.Is(err, secondErr))
+	assert.EqualError(t, err, "first; second")
+}
@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/asyncfloat64"
+	"go.opentelemetry.io/otel/metric/instrument/syncfloat64"
+	"go.opentelemetry.io/otel/metric/unit"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	"sort"
+	"sync"
+	"time"
+)
+
+// otelMeterName is the instrumentation scope of the instruments OTelReporter creates
+const otelMeterName = "github.com/gotechbook/gotechbook-framework-metrics"
+
+// OTLPProtocol selects the transport OTelReporter exports over
+type OTLPProtocol int
+
+const (
+	// OTLPHTTP exports protobuf payloads over HTTP (default)
+	OTLPHTTP OTLPProtocol = iota
+	// OTLPGRPC exports over gRPC
+	OTLPGRPC
+)
+
+// OTelReporter is a Reporter recording on the OpenTelemetry metrics SDK:
+// counts feed counters, gauges feed observable gauges holding the last
+// reported value of each series, summaries and histograms feed histograms
+type OTelReporter struct {
+	provider    *sdkmetric.MeterProvider
+	meter       metric.Meter
+	legacyUnits bool
+
+	mutex      sync.Mutex
+	counters   map[string]syncfloat64.Counter
+	histograms map[string]syncfloat64.Histogram
+	gauges     map[string]*otelGauge
+}
+
+type otelGauge struct {
+	mutex  sync.Mutex
+	series map[string]otelGaugeValue
+}
+
+type otelGaugeValue struct {
+	attrs []attribute.KeyValue
+	value float64
+}
+
+type otelOptions struct {
+	protocol    OTLPProtocol
+	endpoint    string
+	insecure    bool
+	headers     map[string]string
+	interval    time.Duration
+	reader      sdkmetric.Reader
+	legacyUnits bool
+}
+
+// OTelOption configures a OTelReporter built by NewOTelReporter
+type OTelOption func(*otelOptions)
+
+// WithOTLPProtocol chooses between OTLPHTTP and OTLPGRPC
+func WithOTLPProtocol(protocol OTLPProtocol) OTelOption {
+	return func(o *otelOptions) {
+		o.protocol = protocol
+	}
+}
+
+// WithOTLPEndpoint sets the host:port of the collector, the exporters default
+// to localhost:4318 for HTTP and localhost:4317 for gRPC
+func WithOTLPEndpoint(endpoint string) OTelOption {
+	return func(o *otelOptions) {
+		o.endpoint = endpoint
+	}
+}
+
+// WithOTLPInsecure exports without TLS
+func WithOTLPInsecure() OTelOption {
+	return func(o *otelOptions) {
+		o.insecure = true
+	}
+}
+
+// WithOTLPHeaders adds headers to every export request
+func WithOTLPHeaders(headers map[string]string) OTelOption {
+	return func(o *otelOptions) {
+		o.headers = headers
+	}
+}
+
+// WithOTelExportInterval sets how often metrics are exported, the SDK
+// default applies otherwise
+func WithOTelExportInterval(interval time.Duration) OTelOption {
+	return func(o *otelOptions) {
+		o.interval = interval
+	}
+}
+
+// WithOTelReader makes the reporter collect through reader instead of
+// exporting over OTLP
+func WithOTelReader(reader sdkmetric.Reader) OTelOption {
+	return func(o *otelOptions) {
+		o.reader = reader
+	}
+}
+
+// WithOTelLegacyUnits records timing values unchanged under their original
+// name instead of converting them to seconds
+func WithOTelLegacyUnits() OTelOption {
+	return func(o *otelOptions) {
+		o.legacyUnits = true
+	}
+}
+
+// NewOTelReporter returns an OTelReporter whose resource carries serverType
+// and GoTechBookFrameworkMetricsConstTags as attributes
+func NewOTelReporter(metrics config.Metrics, serverType string, opts ...OTelOption) (*OTelReporter, error) {
+	o := &otelOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	reader := o.reader
+	if reader == nil {
+		exporter, err := newOTLPExporter(o)
+		if err != nil {
+			return nil, err
+		}
+		var readerOpts []sdkmetric.PeriodicReaderOption
+		if o.interval > 0 {
+			readerOpts = append(readerOpts, sdkmetric.WithInterval(o.interval))
+		}
+		reader = sdkmetric.NewPeriodicReader(exporter, readerOpts...)
+	}
+
+	attrs := []attribute.KeyValue{attribute.String("serverType", serverType)}
+	for k, v := range metrics.GoTechBookFrameworkMetricsConstTags {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(resource.NewSchemaless(attrs...)),
+		sdkmetric.WithReader(reader),
+	)
+	return &OTelReporter{
+		provider:    provider,
+		meter:       provider.Meter(otelMeterName),
+		legacyUnits: o.legacyUnits,
+		counters:    map[string]syncfloat64.Counter{},
+		histograms:  map[string]syncfloat64.Histogram{},
+		gauges:      map[string]*otelGauge{},
+	}, nil
+}
+
+func newOTLPExporter(o *otelOptions) (sdkmetric.Exporter, error) {
+	ctx := context.Background()
+	switch o.protocol {
+	case OTLPHTTP:
+		var opts []otlpmetrichttp.Option
+		if o.endpoint != "" {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(o.endpoint))
+		}
+		if o.insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if len(o.headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(o.headers))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	case OTLPGRPC:
+		var opts []otlpmetricgrpc.Option
+		if o.endpoint != "" {
+			opts = append(opts, otlpmetricgrpc.WithEndpoint(o.endpoint))
+		}
+		if o.insecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if len(o.headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(o.headers))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %d", o.protocol)
+	}
+}
+
+func (o *OTelReporter) ReportCount(metric string, tags map[string]string, count float64) error {
+	o.mutex.Lock()
+	counter, ok := o.counters[metric]
+	if !ok {
+		var err error
+		counter, err = o.meter.SyncFloat64().Counter(metric)
+		if err != nil {
+			o.mutex.Unlock()
+			return err
+		}
+		o.counters[metric] = counter
+	}
+	o.mutex.Unlock()
+	counter.Add(context.Background(), count, otelAttributes(tags)...)
+	return nil
+}
+
+func (o *OTelReporter) ReportSummary(metric string, tags map[string]string, value float64) error {
+	return o.record(metric, tags, value)
+}
+
+func (o *OTelReporter) ReportHistogram(metric string, tags map[string]string, value float64) error {
+	return o.record(metric, tags, value)
+}
+
+func (o *OTelReporter) record(metric string, tags map[string]string, value float64) error {
+	name, u := metric, otelUnit(MetricUnit(metric))
+	if !o.legacyUnits && secondsPer[MetricUnit(metric)] != 0 {
+		name, u = secondsName(metric), unit.Unit("s")
+		value = convertUnit(value, MetricUnit(metric), UnitSeconds)
+	}
+
+	o.mutex.Lock()
+	histogram, ok := o.histograms[name]
+	if !ok {
+		var err error
+		histogram, err = o.meter.SyncFloat64().Histogram(name, instrument.WithUnit(u))
+		if err != nil {
+			o.mutex.Unlock()
+			return err
+		}
+		o.histograms[name] = histogram
+	}
+	o.mutex.Unlock()
+	histogram.Record(context.Background(), value, otelAttributes(tags)...)
+	return nil
+}
+
+func (o *OTelReporter) ReportGauge(metric string, tags map[string]string, value float64) error {
+	o.mutex.Lock()
+	gauge, ok := o.gauges[metric]
+	if !ok {
+		g, err := o.meter.AsyncFloat64().Gauge(metric, instrument.WithUnit(otelUnit(MetricUnit(metric))))
+		if err != nil {
+			o.mutex.Unlock()
+			return err
+		}
+		gauge = &otelGauge{series: map[string]otelGaugeValue{}}
+		err = o.meter.RegisterCallback([]instrument.Asynchronous{g}, gauge.observe(g))
+		if err != nil {
+			o.mutex.Unlock()
+			return err
+		}
+		o.gauges[metric] = gauge
+	}
+	o.mutex.Unlock()
+
+	gauge.mutex.Lock()
+	gauge.series[seriesKey(metric, tags)] = otelGaugeValue{attrs: otelAttributes(tags), value: value}
+	gauge.mutex.Unlock()
+	return nil
+}
+
+func (g *otelGauge) observe(inst asyncfloat64.Gauge) func(context.Context) {
+	return func(ctx context.Context) {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		for _, s := range g.series {
+			inst.Observe(ctx, s.value, s.attrs...)
+		}
+	}
+}
+
+// Flush exports everything recorded so far
+func (o *OTelReporter) Flush() error {
+	return o.provider.ForceFlush(context.Background())
+}
+
+// Shutdown exports everything recorded so far and stops the exporter
+func (o *OTelReporter) Shutdown(ctx context.Context) error {
+	return o.provider.Shutdown(ctx)
+}
+
+func otelAttributes(tags map[string]string) []attribute.KeyValue {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	attrs := make([]attribute.KeyValue, len(keys))
+	for i, k := range keys {
+		attrs[i] = attribute.String(k, tags[k])
+	}
+	return attrs
+}
+
+func otelUnit(u Unit) unit.Unit {
+	switch u {
+	case UnitNanoseconds:
+		return unit.Unit("ns")
+	case UnitMicroseconds:
+		return unit.Unit("us")
+	case UnitMilliseconds:
+		return unit.Milliseconds
+	case UnitSeconds:
+		return unit.Unit("s")
+	case UnitBytes:
+		return unit.Bytes
+	default:
+		return unit.Dimensionless
+	}
+}
@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"context"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/stretchr/testify/assert"
+	collectormetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// otlpReceiver stands in for an OTLP collector, keeping every export request
+type otlpReceiver struct {
+	collectormetrics.UnimplementedMetricsServiceServer
+	mutex    sync.Mutex
+	requests []*collectormetrics.ExportMetricsServiceRequest
+}
+
+func (r *otlpReceiver) Export(_ context.Context, req *collectormetrics.ExportMetricsServiceRequest) (*collectormetrics.ExportMetricsServiceResponse, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, req)
+	return &collectormetrics.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	export := &collectormetrics.ExportMetricsServiceRequest{}
+	if err := proto.Unmarshal(body, export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.Export(req.Context(), export)
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.WriteHeader(http.StatusOK)
+}
+
+// metrics returns the last exported data point of each metric and the
+// resource attributes of the last request
+func (r *otlpReceiver) metrics() (map[string]*metricspb.Metric, map[string]string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	metrics := map[string]*metricspb.Metric{}
+	attrs := map[string]string{}
+	for _, req := range r.requests {
+		for _, rm := range req.ResourceMetrics {
+			for _, kv := range rm.Resource.Attributes {
+				attrs[kv.Key] = kv.Value.GetStringValue()
+			}
+			for _, sm := range rm.ScopeMetrics {
+				for _, m := range sm.Metrics {
+					metrics[m.Name] = m
+				}
+			}
+		}
+	}
+	return metrics, attrs
+}
+
+func reportOTelSamples(t *testing.T, o *OTelReporter) {
+	t.Helper()
+	tags := map[string]string{"route": "room.join"}
+	assert.NoError(t, o.ReportCount(ExceededRateLimiting, tags, 2))
+	assert.NoError(t, o.ReportCount(ExceededRateLimiting, tags, 3))
+	assert.NoError(t, o.ReportGauge(ConnectedClients, tags, 7))
+	assert.NoError(t, o.ReportGauge(ConnectedClients, tags, 5))
+	assert.NoError(t, o.ReportSummary(ResponseTime, tags, 2e9))
+	assert.NoError(t, o.ReportHistogram("payload_size", tags, 42))
+	assert.NoError(t, o.Flush())
+	assert.NoError(t, o.Shutdown(context.Background()))
+}
+
+func assertOTelSamples(t *testing.T, receiver *otlpReceiver) {
+	t.Helper()
+	metrics, attrs := receiver.metrics()
+	assert.Equal(t, "game", attrs["serverType"])
+	assert.Equal(t, "us-east", attrs["region"])
+
+	if assert.Contains(t, metrics, ExceededRateLimiting) {
+		points := metrics[ExceededRateLimiting].GetSum().DataPoints
+		assert.Len(t, points, 1)
+		assert.Equal(t, float64(5), points[0].GetAsDouble())
+		assert.Equal(t, "room.join", points[0].Attributes[0].Value.GetStringValue())
+	}
+	if assert.Contains(t, metrics, ConnectedClients) {
+		points := metrics[ConnectedClients].GetGauge().DataPoints
+		assert.Len(t, points, 1)
+		assert.Equal(t, float64(5), points[0].GetAsDouble())
+	}
+	if assert.Contains(t, metrics, secondsName(ResponseTime)) {
+		m := metrics[secondsName(ResponseTime)]
+		assert.Equal(t, "s", m.Unit)
+		assert.Equal(t, float64(2), m.GetHistogram().DataPoints[0].GetSum())
+	}
+	if assert.Contains(t, metrics, "payload_size") {
+		points := metrics["payload_size"].GetHistogram().DataPoints
+		assert.Equal(t, uint64(1), points[0].Count)
+		assert.Equal(t, float64(42), points[0].GetSum())
+	}
+}
+
+func TestOTelReporterHTTP(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	o, err := NewOTelReporter(config.Metrics{
+		GoTechBookFrameworkMetricsConstTags: map[string]string{"region": "us-east"},
+	}, "game", WithOTLPEndpoint(strings.TrimPrefix(server.URL, "http://")), WithOTLPInsecure())
+	assert.NoError(t, err)
+	reportOTelSamples(t, o)
+	assertOTelSamples(t, receiver)
+}
+
+func TestOTelReporterGRPC(t *testing.T) {
+	receiver := &otlpReceiver{}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	server := grpc.NewServer()
+	collectormetrics.RegisterMetricsServiceServer(server, receiver)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	o, err := NewOTelReporter(config.Metrics{
+		GoTechBookFrameworkMetricsConstTags: map[string]string{"region": "us-east"},
+	}, "game", WithOTLPProtocol(OTLPGRPC), WithOTLPEndpoint(listener.Addr().String()), WithOTLPInsecure())
+	assert.NoError(t, err)
+	reportOTelSamples(t, o)
+	assertOTelSamples(t, receiver)
+}
+
+func TestOTelReporterUnknownProtocol(t *testing.T) {
+	_, err := NewOTelReporter(config.Metrics{}, "game", WithOTLPProtocol(OTLPProtocol(42)))
+	assert.Error(t, err)
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// userHZ is the unit of the clock ticks reported in /proc/<pid>/stat, it is
+// fixed to 100 on every architecture Linux exposes it to userspace
+const userHZ = 100
+
+// ProcessCollector reads the state of the current process from procfs and
+// reports it to every reporter
+type ProcessCollector struct {
+	reporters []Reporter
+	procfs    string
+}
+
+// NewProcessCollector returns a collector reading from /proc or from the
+// given procfs root
+func NewProcessCollector(reporters []Reporter, procfsOrDefault ...string) *ProcessCollector {
+	procfs := "/proc"
+	if len(procfsOrDefault) > 0 {
+		procfs = procfsOrDefault[0]
+	}
+	return &ProcessCollector{
+		reporters: reporters,
+		procfs:    procfs,
+	}
+}
+
+// Collect reports the process metrics it was able to read, returning the
+// failures of the ones it was not
+func (c *ProcessCollector) Collect() error {
+	values := make(map[string]float64)
+	var errs []error
+
+	errs = append(errs, c.readStat(values))
+	if fds, err := os.ReadDir(c.path("self", "fd")); err != nil {
+		errs = append(errs, err)
+	} else {
+		values[ProcessOpenFDs] = float64(len(fds))
+	}
+	if maxFDs, err := c.readMaxFDs(); err != nil {
+		errs = append(errs, err)
+	} else {
+		values[ProcessMaxFDs] = maxFDs
+	}
+
+	for _, r := range c.reporters {
+		for metric, value := range values {
+			r.ReportGauge(metric, map[string]string{}, value)
+		}
+	}
+	return joinErrors(errs...)
+}
+
+// Run collects every period until ctx is done
+func (c *ProcessCollector) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		c.Collect()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *ProcessCollector) path(elem ...string) string {
+	return filepath.Join(append([]string{c.procfs}, elem...)...)
+}
+
+func (c *ProcessCollector) readStat(values map[string]float64) error {
+	data, err := os.ReadFile(c.path("self", "stat"))
+	if err != nil {
+		return err
+	}
+	// the command name may hold spaces and parenthesis, fields start after the last ')'
+	end := strings.LastIndexByte(string(data), ')')
+	if end < 0 {
+		return fmt.Errorf("malformed %s", c.path("self", "stat"))
+	}
+	fields := strings.Fields(string(data[end+1:]))
+	if len(fields) < 22 {
+		return fmt.Errorf("malformed %s: %d fields", c.path("self", "stat"), len(fields))
+	}
+
+	// fields[0] is the third field of proc(5), state
+	parsed := make([]float64, len(fields))
+	for _, i := range []int{11, 12, 17, 19, 20, 21} {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return fmt.Errorf("malformed %s: %w", c.path("self", "stat"), err)
+		}
+		parsed[i] = v
+	}
+	values[ProcessCPUSeconds] = (parsed[11] + parsed[12]) / userHZ
+	values[ProcessThreads] = parsed[17]
+	values[ProcessVirtualMemory] = parsed[20]
+	values[ProcessResidentMemory] = parsed[21] * float64(os.Getpagesize())
+
+	bootTime, err := c.readBootTime()
+	if err != nil {
+		return err
+	}
+	values[ProcessStartTime] = bootTime + parsed[19]/userHZ
+	return nil
+}
+
+func (c *ProcessCollector) readBootTime() (float64, error) {
+	f, err := os.Open(c.path("stat"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "btime" {
+			return strconv.ParseFloat(fields[1], 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("btime not found in %s", c.path("stat"))
+}
+
+func (c *ProcessCollector) readMaxFDs() (float64, error) {
+	f, err := os.Open(c.path("self", "limits"))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) == 0 {
+			break
+		}
+		if fields[0] == "unlimited" {
+			return 0, nil
+		}
+		return strconv.ParseFloat(fields[0], 64)
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("max open files not found in %s", c.path("self", "limits"))
+}
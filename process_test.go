@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFakeProcfs(t *testing.T, maxOpenFiles string) string {
+	t.Helper()
+	root := t.TempDir()
+	files := map[string]string{
+		"stat": "cpu  1 2 3 4\nbtime 1600000000\nprocesses 42\n",
+		"self/stat": "1234 (game (server)) S 1 1234 1234 0 -1 4194560 100 0 0 0 " +
+			"250 150 0 0 20 0 12 0 500 1048576 256 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 3 0 0 0 0 0\n",
+		"self/limits": "Limit                     Soft Limit           Hard Limit           Units     \n" +
+			"Max cpu time              unlimited            unlimited            seconds   \n" +
+			"Max open files            " + maxOpenFiles + "                 1048576              files     \n",
+		"self/fd/0": "",
+		"self/fd/1": "",
+		"self/fd/2": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return root
+}
+
+func TestProcessCollectorCollect(t *testing.T) {
+	t.Run("fake-procfs", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockMetricsReporter := mocks.NewMockReporter(ctrl)
+
+		reported := map[string]float64{}
+		mockMetricsReporter.EXPECT().ReportGauge(gomock.Any(), map[string]string{}, gomock.Any()).Do(
+			func(metric string, tags map[string]string, value float64) {
+				reported[metric] = value
+			},
+		).AnyTimes()
+
+		root := writeFakeProcfs(t, "1024")
+		assert.NoError(t, NewProcessCollector([]Reporter{mockMetricsReporter}, root).Collect())
+
+		assert.Equal(t, map[string]float64{
+			ProcessCPUSeconds:     4,
+			ProcessThreads:        12,
+			ProcessStartTime:      1600000005,
+			ProcessVirtualMemory:  1048576,
+			ProcessResidentMemory: float64(256 * os.Getpagesize()),
+			ProcessOpenFDs:        3,
+			ProcessMaxFDs:         1024,
+		}, reported)
+	})
+
+	t.Run("unlimited-fds", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockMetricsReporter := mocks.NewMockReporter(ctrl)
+		mockMetricsReporter.EXPECT().ReportGauge(ProcessMaxFDs, map[string]string{}, float64(0))
+		mockMetricsReporter.EXPECT().ReportGauge(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+		root := writeFakeProcfs(t, "unlimited")
+		assert.NoError(t, NewProcessCollector([]Reporter{mockMetricsReporter}, root).Collect())
+	})
+
+	t.Run("missing-procfs", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockMetricsReporter := mocks.NewMockReporter(ctrl)
+
+		assert.Error(t, NewProcessCollector([]Reporter{mockMetricsReporter}, t.TempDir()).Collect())
+	})
+}
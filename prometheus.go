@@ -1,21 +1,30 @@
 package metrics
 
 import (
+	"context"
 	"fmt"
 	config "github.com/gotechbook/gotechbook-framework-config"
 	logger "github.com/gotechbook/gotechbook-framework-logger"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"math"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
 )
 
 var (
-	prometheusReporter *PrometheusReporter
-	once               sync.Once
+	prometheusReporter    *PrometheusReporter
+	prometheusReporterErr error
+	once                  sync.Once
 )
 
 type PrometheusReporter struct {
+	// mu guards the vector and label maps, written by the Register methods
+	// while reports read them
+	mu                    sync.RWMutex
 	serverType            string
 	game                  string
 	countReportersMap     map[string]*prometheus.CounterVec
@@ -23,128 +32,358 @@ type PrometheusReporter struct {
 	histogramReportersMap map[string]*prometheus.HistogramVec
 	gaugeReportersMap     map[string]*prometheus.GaugeVec
 	additionalLabels      map[string]string
+	additionalLabelsKeys  []string
+	constLabels           map[string]string
+	custom                map[metricKey]*customMetric
+	fqNames               map[string]metricKey
+	specMetrics           map[metricKey]bool
+	helps                 map[metricKey]*prometheus.Desc
+	exposed               map[string]bool
+	unchecked             map[metricKey]bool
+	uncheckedRegistered   bool
+	registerer            prometheus.Registerer
+	gatherer              prometheus.Gatherer
+	server                *http.Server
+	addr                  string
+	cardinality           *cardinalityGuard
+	countLabels           map[string][]string
+	summaryLabels         map[string][]string
+	histogramLabels       map[string][]string
+	gaugeLabels           map[string][]string
+	unknownLabelPolicy    UnknownLabelPolicy
+	labelMapping          map[string]string
+	legacyUnits           bool
+	push                  *pushLoop
 }
 
+// UnknownLabelPolicy tells PrometheusReporter what to do with reported labels
+// its vectors do not declare
+type UnknownLabelPolicy int
+
+const (
+	// DropUnknownLabels reports the sample without the undeclared labels
+	DropUnknownLabels UnknownLabelPolicy = iota
+	// RejectUnknownLabels discards the sample and returns ErrLabelMismatch
+	RejectUnknownLabels
+)
+
+type prometheusOptions struct {
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+	mux        *http.ServeMux
+	noServer   bool
+	address    string
+
+	cardinalityLimit        int
+	metricCardinalityLimits map[string]int
+	unknownLabelPolicy      UnknownLabelPolicy
+	labelMapping            map[string]string
+	legacyUnits             bool
+	push                    pushOptions
+}
+
+// PrometheusOption configures a PrometheusReporter built by NewPrometheusReporter
+type PrometheusOption func(*prometheusOptions)
+
+// WithRegisterer registers the reporter collectors into r instead of prometheus.DefaultRegisterer
+func WithRegisterer(r prometheus.Registerer) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.registerer = r
+	}
+}
+
+// WithGatherer serves g on /metrics instead of prometheus.DefaultGatherer
+func WithGatherer(g prometheus.Gatherer) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.gatherer = g
+	}
+}
+
+// WithRegistry uses reg both as registerer and gatherer
+func WithRegistry(reg *prometheus.Registry) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.registerer = reg
+		o.gatherer = reg
+	}
+}
+
+// WithServeMux mounts /metrics on mux, combine it with WithoutServer to expose
+// the metrics on an already running server
+func WithServeMux(mux *http.ServeMux) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.mux = mux
+	}
+}
+
+// WithoutServer disables the HTTP server started by the reporter
+func WithoutServer() PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.noServer = true
+	}
+}
+
+// WithListenAddress overrides the ":<GoTechBookFrameworkMetricsPrometheusPort>" listen address
+func WithListenAddress(address string) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.address = address
+	}
+}
+
+// WithCardinalityLimit caps the number of distinct label sets of every metric,
+// label sets over the limit are reported with OverflowLabelValue values
+func WithCardinalityLimit(limit int) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.cardinalityLimit = limit
+	}
+}
+
+// WithMetricCardinalityLimits overrides WithCardinalityLimit per metric name,
+// a limit of 0 disables the guard for that metric
+func WithMetricCardinalityLimits(limits map[string]int) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.metricCardinalityLimits = limits
+	}
+}
+
+// WithUnknownLabelPolicy sets what happens to labels a vector does not
+// declare, DropUnknownLabels by default
+func WithUnknownLabelPolicy(policy UnknownLabelPolicy) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.unknownLabelPolicy = policy
+	}
+}
+
+// WithLabelMapping renames undeclared labels into declared ones before the
+// unknown label policy applies, e.g. {"handler": "route"}
+func WithLabelMapping(mapping map[string]string) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.labelMapping = mapping
+	}
+}
+
+// WithPrometheusLegacyUnits keeps observing time metrics in the unit they are
+// reported in, with their historical names (response_time_ns, ...), instead
+// of converting them to seconds as Prometheus recommends
+func WithPrometheusLegacyUnits() PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.legacyUnits = true
+	}
+}
+
+// GetPrometheusReporter returns the process wide reporter registered into the
+// default prometheus registry and served on http.DefaultServeMux. It keeps the
+// legacy units and names of the time metrics, which existing dashboards query,
+// use NewPrometheusReporter to expose them in seconds
 func GetPrometheusReporter(serverType string, metrics config.Metrics, spec *config.CustomMetricsSpec) (*PrometheusReporter, error) {
 	once.Do(func() {
-		prometheusReporter = &PrometheusReporter{
-			serverType:            serverType,
-			game:                  "",
-			countReportersMap:     make(map[string]*prometheus.CounterVec),
-			summaryReportersMap:   make(map[string]*prometheus.SummaryVec),
-			histogramReportersMap: make(map[string]*prometheus.HistogramVec),
-			gaugeReportersMap:     make(map[string]*prometheus.GaugeVec),
-		}
-		prometheusReporter.registerMetrics(metrics.GoTechBookFrameworkMetricsConstTags, metrics.GoTechBookFrameworkMetricsPrometheusAdditionalTags, spec)
-		http.Handle("/metrics", promhttp.Handler())
-		go (func() {
-			err := http.ListenAndServe(fmt.Sprintf(":%d", metrics.GoTechBookFrameworkMetricsPrometheusPort), nil)
-			if err != nil {
-				logger.Log.Error("prometheus reporter serve start failed, err: ", err)
-			}
-		})()
+		prometheusReporter, prometheusReporterErr = NewPrometheusReporter(serverType, metrics, spec, WithServeMux(http.DefaultServeMux), WithPrometheusLegacyUnits())
 	})
-	return prometheusReporter, nil
-}
-func (p *PrometheusReporter) registerCustomMetrics(constLabels map[string]string, additionalLabelsKeys []string, spec *config.CustomMetricsSpec) {
-	for _, summary := range spec.Summaries {
-		p.summaryReportersMap[summary.Name] = prometheus.NewSummaryVec(
-			prometheus.SummaryOpts{
-				Namespace:   config.PREFIX,
-				Subsystem:   summary.Subsystem,
-				Name:        summary.Name,
-				Help:        summary.Help,
-				Objectives:  summary.Objectives,
-				ConstLabels: constLabels,
-			},
-			append(additionalLabelsKeys, summary.Labels...),
-		)
-	}
-
-	for _, histogram := range spec.Histograms {
-		p.histogramReportersMap[histogram.Name] = prometheus.NewHistogramVec(
-			prometheus.HistogramOpts{
-				Namespace:   config.PREFIX,
-				Subsystem:   histogram.Subsystem,
-				Name:        histogram.Name,
-				Help:        histogram.Help,
-				Buckets:     histogram.Buckets,
-				ConstLabels: constLabels,
-			},
-			append(additionalLabelsKeys, histogram.Labels...),
-		)
-	}
-	for _, gauge := range spec.Gauges {
-		p.gaugeReportersMap[gauge.Name] = prometheus.NewGaugeVec(
-			prometheus.GaugeOpts{
-				Namespace:   config.PREFIX,
-				Subsystem:   gauge.Subsystem,
-				Name:        gauge.Name,
-				Help:        gauge.Help,
-				ConstLabels: constLabels,
-			},
-			append(additionalLabelsKeys, gauge.Labels...),
-		)
-	}
-	for _, counter := range spec.Counters {
-		p.countReportersMap[counter.Name] = prometheus.NewCounterVec(
-			prometheus.CounterOpts{
-				Namespace:   config.PREFIX,
-				Subsystem:   counter.Subsystem,
-				Name:        counter.Name,
-				Help:        counter.Help,
-				ConstLabels: constLabels,
-			},
-			append(additionalLabelsKeys, counter.Labels...),
-		)
-	}
-}
-func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[string]string, spec *config.CustomMetricsSpec) {
+	return prometheusReporter, prometheusReporterErr
+}
+
+// NewPrometheusReporter builds a reporter, registers its collectors and, unless
+// WithoutServer is given, starts serving /metrics. Registration and listen
+// failures are returned instead of being logged
+func NewPrometheusReporter(serverType string, metrics config.Metrics, spec *config.CustomMetricsSpec, opts ...PrometheusOption) (*PrometheusReporter, error) {
+	o := &prometheusOptions{
+		registerer: prometheus.DefaultRegisterer,
+		gatherer:   prometheus.DefaultGatherer,
+		address:    fmt.Sprintf(":%d", metrics.GoTechBookFrameworkMetricsPrometheusPort),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if spec == nil {
+		spec = &config.CustomMetricsSpec{}
+	}
+
+	p := &PrometheusReporter{
+		serverType:            serverType,
+		game:                  "",
+		countReportersMap:     make(map[string]*prometheus.CounterVec),
+		summaryReportersMap:   make(map[string]*prometheus.SummaryVec),
+		histogramReportersMap: make(map[string]*prometheus.HistogramVec),
+		gaugeReportersMap:     make(map[string]*prometheus.GaugeVec),
+		registerer:            o.registerer,
+		gatherer:              o.gatherer,
+		cardinality:           newCardinalityGuard(o.cardinalityLimit, o.metricCardinalityLimits),
+		countLabels:           make(map[string][]string),
+		summaryLabels:         make(map[string][]string),
+		histogramLabels:       make(map[string][]string),
+		gaugeLabels:           make(map[string][]string),
+		custom:                make(map[metricKey]*customMetric),
+		fqNames:               make(map[string]metricKey),
+		specMetrics:           make(map[metricKey]bool),
+		helps:                 make(map[metricKey]*prometheus.Desc),
+		exposed:               make(map[string]bool),
+		unchecked:             make(map[metricKey]bool),
+		unknownLabelPolicy:    o.unknownLabelPolicy,
+		labelMapping:          o.labelMapping,
+		legacyUnits:           o.legacyUnits,
+	}
+	constLabels := make(map[string]string, len(metrics.GoTechBookFrameworkMetricsConstTags)+2)
+	for k, v := range metrics.GoTechBookFrameworkMetricsConstTags {
+		constLabels[k] = v
+	}
+	if err := p.registerMetrics(constLabels, metrics.GoTechBookFrameworkMetricsPrometheusAdditionalTags, spec); err != nil {
+		return nil, err
+	}
+	if o.push.url != "" {
+		o.push.groupingLabels = map[string]string{"serverType": serverType}
+		for k, v := range metrics.GoTechBookFrameworkMetricsConstTags {
+			o.push.groupingLabels[k] = v
+		}
+		p.push = newPushLoop(o.push, o.gatherer)
+	}
+
+	mux := o.mux
+	if mux == nil {
+		if o.noServer {
+			return p, nil
+		}
+		mux = http.NewServeMux()
+	}
+	handler := promhttp.HandlerFor(o.gatherer, promhttp.HandlerOpts{})
+	// injected registerers are left holding our metrics only
+	if o.registerer == prometheus.DefaultRegisterer {
+		handler = promhttp.InstrumentMetricHandler(o.registerer, handler)
+	}
+	mux.Handle("/metrics", handler)
+	if o.noServer {
+		return p, nil
+	}
+
+	listener, err := net.Listen("tcp", o.address)
+	if err != nil {
+		p.unregisterMetrics()
+		if p.push != nil {
+			p.push.halt()
+		}
+		return nil, err
+	}
+	p.addr = listener.Addr().String()
+	p.server = &http.Server{Handler: mux}
+	go func() {
+		if err := p.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			logger.Log.Error("prometheus reporter serve failed, err: ", err)
+		}
+	}()
+	return p, nil
+}
+
+// Addr returns the address the reporter HTTP server listens on, or an empty
+// string when no server was started
+func (p *PrometheusReporter) Addr() string {
+	return p.addr
+}
+
+// timeName is the exposed name of a built-in time metric
+func (p *PrometheusReporter) timeName(name string) string {
+	if p.legacyUnits {
+		return name
+	}
+	return secondsName(name)
+}
+
+func (p *PrometheusReporter) timeUnitName() string {
+	if p.legacyUnits {
+		return "nanoseconds"
+	}
+	return "seconds"
+}
+
+// timeBuckets returns prometheus.DefBuckets, scaled to nanoseconds with
+// legacy units
+func (p *PrometheusReporter) timeBuckets() []float64 {
+	if !p.legacyUnits {
+		return prometheus.DefBuckets
+	}
+	buckets := make([]float64, len(prometheus.DefBuckets))
+	for i, b := range prometheus.DefBuckets {
+		buckets[i] = math.Round(b * 1e9)
+	}
+	return buckets
+}
+
+// nativeValue converts time values to seconds unless legacy units are kept
+func (p *PrometheusReporter) nativeValue(metric string, value float64) float64 {
+	if p.legacyUnits {
+		return value
+	}
+	return convertUnit(value, MetricUnit(metric), UnitSeconds)
+}
+
+func (p *PrometheusReporter) declareCounter(metric string, opts prometheus.CounterOpts, labelNames []string) {
+	p.countReportersMap[metric] = prometheus.NewCounterVec(opts, labelNames)
+	p.countLabels[metric] = labelNames
+	p.fqNames[prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)] = metricKey{kind: countSample, name: metric}
+}
+
+func (p *PrometheusReporter) declareSummary(metric string, opts prometheus.SummaryOpts, labelNames []string) {
+	p.summaryReportersMap[metric] = prometheus.NewSummaryVec(opts, labelNames)
+	p.summaryLabels[metric] = labelNames
+	p.fqNames[prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)] = metricKey{kind: summarySample, name: metric}
+}
+
+func (p *PrometheusReporter) declareHistogram(metric string, opts prometheus.HistogramOpts, labelNames []string) {
+	p.histogramReportersMap[metric] = prometheus.NewHistogramVec(opts, labelNames)
+	p.histogramLabels[metric] = labelNames
+	p.fqNames[prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)] = metricKey{kind: histogramSample, name: metric}
+}
+
+func (p *PrometheusReporter) declareGauge(metric string, opts prometheus.GaugeOpts, labelNames []string) {
+	p.gaugeReportersMap[metric] = prometheus.NewGaugeVec(opts, labelNames)
+	p.gaugeLabels[metric] = labelNames
+	p.fqNames[prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name)] = metricKey{kind: gaugeSample, name: metric}
+}
+
+func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[string]string, spec *config.CustomMetricsSpec) error {
 	constLabels["game"] = p.game
 	constLabels["serverType"] = p.serverType
 
+	p.constLabels = constLabels
 	p.additionalLabels = additionalLabels
 	additionalLabelsKeys := make([]string, 0, len(additionalLabels))
 	for key := range additionalLabels {
 		additionalLabelsKeys = append(additionalLabelsKeys, key)
 	}
-	p.registerCustomMetrics(constLabels, additionalLabelsKeys, spec)
+	p.additionalLabelsKeys = additionalLabelsKeys
 
-	p.summaryReportersMap[ResponseTime] = prometheus.NewSummaryVec(
+	p.declareSummary(ResponseTime,
 		prometheus.SummaryOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "handler",
-			Name:        ResponseTime,
-			Help:        "the time to process a msg in nanoseconds",
+			Name:        p.timeName(ResponseTime),
+			Help:        "the time to process a msg in " + p.timeUnitName(),
 			Objectives:  map[float64]float64{0.7: 0.02, 0.95: 0.005, 0.99: 0.001},
 			ConstLabels: constLabels,
 		},
 		append([]string{"route", "status", "type", "code"}, additionalLabelsKeys...),
 	)
 
-	p.histogramReportersMap[ResponseTime] = prometheus.NewHistogramVec(
+	p.declareHistogram(ResponseTime,
 		prometheus.HistogramOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "handler",
-			Name:        ResponseTime,
-			Help:        "the time to process a msg in nanoseconds",
-			Buckets:     []float64{1, 5, 10, 50, 100, 300, 500, 1000, 5000, 10000},
+			Name:        p.timeName(ResponseTimeHistogram),
+			Help:        "the time to process a msg in " + p.timeUnitName(),
+			Buckets:     p.timeBuckets(),
 			ConstLabels: constLabels,
 		},
 		append([]string{"route", "status", "type", "code"}, additionalLabelsKeys...),
 	)
-	p.summaryReportersMap[ProcessDelay] = prometheus.NewSummaryVec(
+	p.declareSummary(ProcessDelay,
 		prometheus.SummaryOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "handler",
-			Name:        ProcessDelay,
-			Help:        "the delay to start processing a msg in nanoseconds",
+			Name:        p.timeName(ProcessDelay),
+			Help:        "the delay to start processing a msg in " + p.timeUnitName(),
 			Objectives:  map[float64]float64{0.7: 0.02, 0.95: 0.005, 0.99: 0.001},
 			ConstLabels: constLabels,
 		},
 		append([]string{"route", "type"}, additionalLabelsKeys...),
 	)
-	p.gaugeReportersMap[ConnectedClients] = prometheus.NewGaugeVec(
+	p.declareGauge(ConnectedClients,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "acceptor",
@@ -154,7 +393,7 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		},
 		additionalLabelsKeys,
 	)
-	p.gaugeReportersMap[CountServers] = prometheus.NewGaugeVec(
+	p.declareGauge(CountServers,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "service_discovery",
@@ -164,7 +403,7 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		},
 		append([]string{"type"}, additionalLabelsKeys...),
 	)
-	p.gaugeReportersMap[ChannelCapacity] = prometheus.NewGaugeVec(
+	p.declareGauge(ChannelCapacity,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "channel",
@@ -175,7 +414,7 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		append([]string{"channel"}, additionalLabelsKeys...),
 	)
 
-	p.gaugeReportersMap[DroppedMessages] = prometheus.NewGaugeVec(
+	p.declareGauge(DroppedMessages,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "rpc_server",
@@ -186,7 +425,7 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		additionalLabelsKeys,
 	)
 
-	p.gaugeReportersMap[Goroutines] = prometheus.NewGaugeVec(
+	p.declareGauge(Goroutines,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "sys",
@@ -196,7 +435,7 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		},
 		additionalLabelsKeys,
 	)
-	p.gaugeReportersMap[HeapSize] = prometheus.NewGaugeVec(
+	p.declareGauge(HeapSize,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "sys",
@@ -207,7 +446,7 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		additionalLabelsKeys,
 	)
 
-	p.gaugeReportersMap[HeapObjects] = prometheus.NewGaugeVec(
+	p.declareGauge(HeapObjects,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "sys",
@@ -218,7 +457,165 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		additionalLabelsKeys,
 	)
 
-	p.gaugeReportersMap[WorkerJobsRetry] = prometheus.NewGaugeVec(
+	p.declareGauge(GCPauses,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "sys",
+			Name:        GCPauses,
+			Help:        "quantiles of the GC stop-the-world pause latencies in seconds since the last collection",
+			ConstLabels: constLabels,
+		},
+		append([]string{"quantile"}, additionalLabelsKeys...),
+	)
+
+	p.declareCounter(GCCycles,
+		prometheus.CounterOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "sys",
+			Name:        GCCycles,
+			Help:        "the number of completed GC cycles",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(SchedLatencies,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "sys",
+			Name:        SchedLatencies,
+			Help:        "quantiles of the time goroutines spent runnable before running in seconds since the last collection",
+			ConstLabels: constLabels,
+		},
+		append([]string{"quantile"}, additionalLabelsKeys...),
+	)
+
+	p.declareGauge(HeapGoal,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "sys",
+			Name:        HeapGoal,
+			Help:        "the heap size target for the end of the GC cycle",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(StackMemory,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "sys",
+			Name:        StackMemory,
+			Help:        "the memory used by goroutine stacks",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(OSMemory,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "sys",
+			Name:        OSMemory,
+			Help:        "the memory mapped by the Go runtime from the OS",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareCounter(CgoCalls,
+		prometheus.CounterOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "sys",
+			Name:        CgoCalls,
+			Help:        "the number of cgo calls made by the process",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(GoMaxProcs,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "sys",
+			Name:        GoMaxProcs,
+			Help:        "the current GOMAXPROCS value",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(ProcessCPUSeconds,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Name:        ProcessCPUSeconds,
+			Help:        "the user and system CPU time spent in seconds",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(ProcessResidentMemory,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Name:        ProcessResidentMemory,
+			Help:        "the resident memory size in bytes",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(ProcessVirtualMemory,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Name:        ProcessVirtualMemory,
+			Help:        "the virtual memory size in bytes",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(ProcessOpenFDs,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Name:        ProcessOpenFDs,
+			Help:        "the number of open file descriptors",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(ProcessMaxFDs,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Name:        ProcessMaxFDs,
+			Help:        "the maximum number of open file descriptors, 0 when unlimited",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(ProcessThreads,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Name:        ProcessThreads,
+			Help:        "the number of OS threads",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(ProcessStartTime,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Name:        ProcessStartTime,
+			Help:        "the start time of the process since unix epoch in seconds",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(WorkerJobsRetry,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "worker",
@@ -229,7 +626,7 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		additionalLabelsKeys,
 	)
 
-	p.gaugeReportersMap[WorkerQueueSize] = prometheus.NewGaugeVec(
+	p.declareGauge(WorkerQueueSize,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "worker",
@@ -240,7 +637,7 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		append([]string{"queue"}, additionalLabelsKeys...),
 	)
 
-	p.gaugeReportersMap[WorkerJobsTotal] = prometheus.NewGaugeVec(
+	p.declareGauge(WorkerJobsTotal,
 		prometheus.GaugeOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "worker",
@@ -251,7 +648,29 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		append([]string{"status"}, additionalLabelsKeys...),
 	)
 
-	p.countReportersMap[ExceededRateLimiting] = prometheus.NewCounterVec(
+	p.declareGauge(AsyncQueueSize,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "async_reporter",
+			Name:        AsyncQueueSize,
+			Help:        "the number of samples waiting to be reported",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareGauge(AsyncDroppedSamples,
+		prometheus.GaugeOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "async_reporter",
+			Name:        AsyncDroppedSamples,
+			Help:        "the number of samples dropped because the queue was full",
+			ConstLabels: constLabels,
+		},
+		additionalLabelsKeys,
+	)
+
+	p.declareCounter(ExceededRateLimiting,
 		prometheus.CounterOpts{
 			Namespace:   config.PREFIX,
 			Subsystem:   "acceptor",
@@ -262,20 +681,111 @@ func (p *PrometheusReporter) registerMetrics(constLabels, additionalLabels map[s
 		additionalLabelsKeys,
 	)
 
-	toRegister := make([]prometheus.Collector, 0)
-	for _, c := range p.countReportersMap {
-		toRegister = append(toRegister, c)
+	p.declareCounter(CardinalityOverflow,
+		prometheus.CounterOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "metrics",
+			Name:        CardinalityOverflow,
+			Help:        "the number of samples collapsed into the overflow series by the cardinality limit",
+			ConstLabels: constLabels,
+		},
+		append([]string{"metric"}, additionalLabelsKeys...),
+	)
+
+	p.declareCounter(MalformedContext,
+		prometheus.CounterOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "metrics",
+			Name:        MalformedContext,
+			Help:        "the number of contexts missing the request start time or route, by missing key",
+			ConstLabels: constLabels,
+		},
+		append([]string{"key"}, additionalLabelsKeys...),
+	)
+
+	p.declareCounter(CustomMetricsReloads,
+		prometheus.CounterOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "metrics",
+			Name:        CustomMetricsReloads,
+			Help:        "the number of custom metrics spec reloads, by status",
+			ConstLabels: constLabels,
+		},
+		append([]string{"status"}, additionalLabelsKeys...),
+	)
+
+	p.declareCounter(LabelMismatch,
+		prometheus.CounterOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   "metrics",
+			Name:        LabelMismatch,
+			Help:        "the number of samples whose labels did not match the declared ones, by reason",
+			ConstLabels: constLabels,
+		},
+		append([]string{"metric", "reason"}, additionalLabelsKeys...),
+	)
+
+	toRegister := p.collectors()
+	for i, c := range toRegister {
+		if err := p.registerer.Register(c); err != nil {
+			for _, registered := range toRegister[:i] {
+				p.registerer.Unregister(registered)
+			}
+			return err
+		}
 	}
 
-	for _, c := range p.gaugeReportersMap {
-		toRegister = append(toRegister, c)
+	for _, def := range customMetricsFromSpec(spec) {
+		exists, err := p.checkCustom(def)
+		if err == nil && !exists {
+			err = p.declareCustom(def, p.newCustomVector(def))
+		}
+		if err != nil {
+			p.unregisterMetrics()
+			return err
+		}
+		p.specMetrics[def.key()] = true
 	}
+	return nil
+}
 
-	for _, c := range p.summaryReportersMap {
-		toRegister = append(toRegister, c)
+// collectors returns the built-in vectors, the custom ones are registered by
+// declareCustom
+func (p *PrometheusReporter) collectors() []prometheus.Collector {
+	collectors := make([]prometheus.Collector, 0)
+	for name, c := range p.countReportersMap {
+		if _, ok := p.custom[metricKey{kind: countSample, name: name}]; !ok {
+			collectors = append(collectors, c)
+		}
 	}
+	for name, c := range p.gaugeReportersMap {
+		if _, ok := p.custom[metricKey{kind: gaugeSample, name: name}]; !ok {
+			collectors = append(collectors, c)
+		}
+	}
+	for name, c := range p.summaryReportersMap {
+		if _, ok := p.custom[metricKey{kind: summarySample, name: name}]; !ok {
+			collectors = append(collectors, c)
+		}
+	}
+	for name, c := range p.histogramReportersMap {
+		if _, ok := p.custom[metricKey{kind: histogramSample, name: name}]; !ok {
+			collectors = append(collectors, c)
+		}
+	}
+	return collectors
+}
 
-	prometheus.MustRegister(toRegister...)
+// unregisterMetrics unregisters the built-in and the custom vectors
+func (p *PrometheusReporter) unregisterMetrics() {
+	for _, c := range p.collectors() {
+		p.registerer.Unregister(c)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.custom {
+		p.undeclare(key)
+	}
 }
 func (p *PrometheusReporter) ensureLabels(labels map[string]string) map[string]string {
 	for key, defaultVal := range p.additionalLabels {
@@ -285,39 +795,186 @@ func (p *PrometheusReporter) ensureLabels(labels map[string]string) map[string]s
 	}
 	return labels
 }
+
+// limitCardinality swaps labels for the overflow series when metric is over
+// its cardinality limit, counting the collapsed samples in CardinalityOverflow
+func (p *PrometheusReporter) limitCardinality(metric string, labels map[string]string) map[string]string {
+	if p.cardinality == nil {
+		return labels
+	}
+	labels, collapsed := p.cardinality.admit(metric, labels)
+	if collapsed {
+		p.countReportersMap[CardinalityOverflow].With(p.ensureLabels(map[string]string{"metric": metric})).Inc()
+	}
+	return labels
+}
+
+// reconcileLabels returns a copy of labels holding exactly the declared
+// label names: undeclared labels are renamed by the label mapping or handled
+// by the unknown label policy, missing ones take their additional label
+// default or an empty value. Every mismatch is counted in LabelMismatch
+func (p *PrometheusReporter) reconcileLabels(metric string, declared []string, labels map[string]string) (map[string]string, error) {
+	reconciled := make(map[string]string, len(declared))
+	for _, name := range declared {
+		if v, ok := labels[name]; ok {
+			reconciled[name] = v
+		}
+	}
+
+	var unknown []string
+	for k, v := range labels {
+		if _, ok := reconciled[k]; ok {
+			continue
+		}
+		if mapped, ok := p.labelMapping[k]; ok && containsLabel(declared, mapped) {
+			if _, taken := labels[mapped]; !taken {
+				reconciled[mapped] = v
+				continue
+			}
+		}
+		unknown = append(unknown, k)
+	}
+	if len(unknown) > 0 {
+		p.countLabelMismatch(metric, "unknown")
+		if p.unknownLabelPolicy == RejectUnknownLabels {
+			sort.Strings(unknown)
+			return nil, fmt.Errorf("%w: %s does not declare %s", ErrLabelMismatch, metric, strings.Join(unknown, ", "))
+		}
+	}
+
+	missing := false
+	for _, name := range declared {
+		if _, ok := reconciled[name]; ok {
+			continue
+		}
+		if v, ok := p.additionalLabels[name]; ok {
+			reconciled[name] = v
+			continue
+		}
+		reconciled[name] = ""
+		missing = true
+	}
+	if missing {
+		p.countLabelMismatch(metric, "missing")
+	}
+	return reconciled, nil
+}
+
+func (p *PrometheusReporter) countLabelMismatch(metric, reason string) {
+	p.countReportersMap[LabelMismatch].With(p.ensureLabels(map[string]string{
+		"metric": metric,
+		"reason": reason,
+	})).Inc()
+}
+
+func containsLabel(labelNames []string, name string) bool {
+	for _, n := range labelNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *PrometheusReporter) ReportSummary(metric string, labels map[string]string, value float64) error {
+	return p.ReportSummaryWeighted(metric, labels, value, 1)
+}
+
+// ReportSummaryWeighted observes value count times
+func (p *PrometheusReporter) ReportSummaryWeighted(metric string, labels map[string]string, value float64, count uint64) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	sum := p.summaryReportersMap[metric]
 	if sum != nil {
-		labels = p.ensureLabels(labels)
-		sum.With(labels).Observe(value)
+		labels, err := p.reconcileLabels(metric, p.summaryLabels[metric], labels)
+		if err != nil {
+			return err
+		}
+		obs, err := sum.GetMetricWith(p.limitCardinality(metric, labels))
+		if err != nil {
+			return err
+		}
+		value = p.nativeValue(metric, value)
+		for ; count > 0; count-- {
+			obs.Observe(value)
+		}
 		return nil
 	}
 	return ErrMetricNotKnown
 }
 func (p *PrometheusReporter) ReportHistogram(metric string, labels map[string]string, value float64) error {
+	return p.ReportHistogramWeighted(metric, labels, value, 1)
+}
+
+// ReportHistogramWeighted observes value count times
+func (p *PrometheusReporter) ReportHistogramWeighted(metric string, labels map[string]string, value float64, count uint64) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	hist := p.histogramReportersMap[metric]
 	if hist != nil {
-		labels = p.ensureLabels(labels)
-		hist.With(labels).Observe(value)
+		labels, err := p.reconcileLabels(metric, p.histogramLabels[metric], labels)
+		if err != nil {
+			return err
+		}
+		obs, err := hist.GetMetricWith(p.limitCardinality(metric, labels))
+		if err != nil {
+			return err
+		}
+		value = p.nativeValue(metric, value)
+		for ; count > 0; count-- {
+			obs.Observe(value)
+		}
 		return nil
 	}
 	return ErrMetricNotKnown
 }
 func (p *PrometheusReporter) ReportCount(metric string, labels map[string]string, count float64) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	cnt := p.countReportersMap[metric]
 	if cnt != nil {
-		labels = p.ensureLabels(labels)
-		cnt.With(labels).Add(count)
+		labels, err := p.reconcileLabels(metric, p.countLabels[metric], labels)
+		if err != nil {
+			return err
+		}
+		c, err := cnt.GetMetricWith(p.limitCardinality(metric, labels))
+		if err != nil {
+			return err
+		}
+		c.Add(count)
 		return nil
 	}
 	return ErrMetricNotKnown
 }
 func (p *PrometheusReporter) ReportGauge(metric string, labels map[string]string, value float64) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	g := p.gaugeReportersMap[metric]
 	if g != nil {
-		labels = p.ensureLabels(labels)
-		g.With(labels).Set(value)
+		labels, err := p.reconcileLabels(metric, p.gaugeLabels[metric], labels)
+		if err != nil {
+			return err
+		}
+		gauge, err := g.GetMetricWith(p.limitCardinality(metric, labels))
+		if err != nil {
+			return err
+		}
+		gauge.Set(value)
 		return nil
 	}
 	return ErrMetricNotKnown
 }
+
+// Shutdown stops the HTTP server started by NewPrometheusReporter, waiting for
+// in-flight scrapes until ctx is done, and makes the last push when
+// WithPushgateway is set
+func (p *PrometheusReporter) Shutdown(ctx context.Context) error {
+	var errs []error
+	if p.push != nil {
+		errs = append(errs, p.push.shutdown(ctx))
+	}
+	if p.server != nil {
+		errs = append(errs, p.server.Shutdown(ctx))
+	}
+	return joinErrors(errs...)
+}
@@ -0,0 +1,193 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	gContext "github.com/gotechbook/gotechbook-framework-context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestPrometheusReporter(t *testing.T, spec *config.CustomMetricsSpec, opts ...PrometheusOption) (*PrometheusReporter, *prometheus.Registry) {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	opts = append([]PrometheusOption{WithRegistry(registry), WithoutServer()}, opts...)
+	p, err := NewPrometheusReporter("game", config.Metrics{}, spec, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, registry
+}
+
+func TestPrometheusReporterHistograms(t *testing.T) {
+	spec := &config.CustomMetricsSpec{
+		Histograms: []*config.Histogram{{
+			Subsystem: "room",
+			Name:      "payload_size",
+			Help:      "the size of room payloads",
+			Buckets:   []float64{10, 100},
+			Labels:    []string{"room"},
+		}},
+	}
+	p, registry := newTestPrometheusReporter(t, spec)
+
+	assert.NoError(t, p.ReportHistogram("payload_size", map[string]string{"room": "lobby"}, 42))
+	assert.NoError(t, p.ReportHistogram(ResponseTime, map[string]string{
+		"route": "room.join", "status": "ok", "type": "handler", "code": "",
+	}, 7))
+
+	expected := `
+# HELP gotechbook_room_payload_size the size of room payloads
+# TYPE gotechbook_room_payload_size histogram
+gotechbook_room_payload_size_bucket{game="",room="lobby",serverType="game",le="10"} 0
+gotechbook_room_payload_size_bucket{game="",room="lobby",serverType="game",le="100"} 1
+gotechbook_room_payload_size_bucket{game="",room="lobby",serverType="game",le="+Inf"} 1
+gotechbook_room_payload_size_sum{game="",room="lobby",serverType="game"} 42
+gotechbook_room_payload_size_count{game="",room="lobby",serverType="game"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "gotechbook_room_payload_size"))
+
+	count, err := testutil.GatherAndCount(registry, "gotechbook_handler_"+secondsName(ResponseTimeHistogram))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestNewPrometheusReporter(t *testing.T) {
+	t.Run("several-instances", func(t *testing.T) {
+		p1, r1 := newTestPrometheusReporter(t, nil)
+		p2, r2 := newTestPrometheusReporter(t, nil)
+
+		assert.NoError(t, p1.ReportGauge(ConnectedClients, map[string]string{}, 1))
+		assert.NoError(t, p2.ReportGauge(ConnectedClients, map[string]string{}, 2))
+
+		assert.NoError(t, testutil.GatherAndCompare(r1, strings.NewReader(`
+# HELP gotechbook_acceptor_connected_clients the number of clients connected right now
+# TYPE gotechbook_acceptor_connected_clients gauge
+gotechbook_acceptor_connected_clients{game="",serverType="game"} 1
+`), "gotechbook_acceptor_connected_clients"))
+		assert.NoError(t, testutil.GatherAndCompare(r2, strings.NewReader(`
+# HELP gotechbook_acceptor_connected_clients the number of clients connected right now
+# TYPE gotechbook_acceptor_connected_clients gauge
+gotechbook_acceptor_connected_clients{game="",serverType="game"} 2
+`), "gotechbook_acceptor_connected_clients"))
+	})
+
+	t.Run("conflicting-registration", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+		_, err := NewPrometheusReporter("game", config.Metrics{}, nil, WithRegistry(registry), WithoutServer())
+		assert.NoError(t, err)
+		_, err = NewPrometheusReporter("game", config.Metrics{}, nil, WithRegistry(registry), WithoutServer())
+		assert.Error(t, err)
+	})
+
+	t.Run("serve-mux", func(t *testing.T) {
+		mux := http.NewServeMux()
+		p, _ := newTestPrometheusReporter(t, nil, WithServeMux(mux))
+		assert.NoError(t, p.ReportGauge(ConnectedClients, map[string]string{}, 3))
+
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `gotechbook_acceptor_connected_clients{game="",serverType="game"} 3`)
+		assert.NotContains(t, rec.Body.String(), "promhttp_metric_handler")
+	})
+
+	t.Run("listen", func(t *testing.T) {
+		registry := prometheus.NewRegistry()
+		p, err := NewPrometheusReporter("game", config.Metrics{}, nil, WithRegistry(registry), WithListenAddress("127.0.0.1:0"))
+		assert.NoError(t, err)
+		assert.NotEmpty(t, p.Addr())
+
+		resp, err := http.Get("http://" + p.Addr() + "/metrics")
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		_, err = NewPrometheusReporter("game", config.Metrics{}, nil, WithRegistry(prometheus.NewRegistry()), WithListenAddress(p.Addr()))
+		assert.Error(t, err)
+
+		assert.NoError(t, p.Shutdown(context.Background()))
+		_, err = http.Get("http://" + p.Addr() + "/metrics")
+		assert.Error(t, err)
+	})
+}
+
+func TestPrometheusReporterCardinalityLimit(t *testing.T) {
+	spec := &config.CustomMetricsSpec{
+		Counters: []*config.Counter{{
+			Subsystem: "room",
+			Name:      "joins",
+			Help:      "the number of room joins",
+			Labels:    []string{"route"},
+		}, {
+			Subsystem: "room",
+			Name:      "leaves",
+			Help:      "the number of room leaves",
+			Labels:    []string{"route"},
+		}},
+	}
+	p, registry := newTestPrometheusReporter(t, spec, WithCardinalityLimit(2), WithMetricCardinalityLimits(map[string]int{"leaves": 0}))
+
+	for _, route := range []string{"a", "b", "c", "d", "c", "a"} {
+		assert.NoError(t, p.ReportCount("joins", map[string]string{"route": route}, 1))
+		assert.NoError(t, p.ReportCount("leaves", map[string]string{"route": route}, 1))
+	}
+
+	joins := p.countReportersMap["joins"]
+	assert.Equal(t, float64(2), testutil.ToFloat64(joins.WithLabelValues("a")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(joins.WithLabelValues("b")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(joins.WithLabelValues(OverflowLabelValue)))
+
+	count, err := testutil.GatherAndCount(registry, "gotechbook_room_leaves")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, count)
+
+	overflow := p.countReportersMap[CardinalityOverflow]
+	assert.Equal(t, float64(3), testutil.ToFloat64(overflow.WithLabelValues("joins")))
+	assert.Equal(t, float64(0), testutil.ToFloat64(overflow.WithLabelValues("leaves")))
+}
+
+func TestPrometheusReporterReconcileLabels(t *testing.T) {
+	t.Run("drop-unknown", func(t *testing.T) {
+		p, registry := newTestPrometheusReporter(t, nil)
+
+		ctx := gContext.AddToPropagateCtx(newTimingCtx("room.join"), MetricTagsKey, map[string]string{"player": "42"})
+		assert.NoError(t, ReportTimingFromCtx(ctx, []Reporter{p}, "handler", nil))
+
+		count, err := testutil.GatherAndCount(registry, "gotechbook_handler_"+secondsName(ResponseTime))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+		mismatch := p.countReportersMap[LabelMismatch]
+		assert.Equal(t, float64(1), testutil.ToFloat64(mismatch.WithLabelValues(ResponseTime, "unknown")))
+	})
+
+	t.Run("fill-missing", func(t *testing.T) {
+		p, _ := newTestPrometheusReporter(t, nil)
+
+		assert.NoError(t, p.ReportGauge(CountServers, map[string]string{}, 3))
+		assert.Equal(t, float64(3), testutil.ToFloat64(p.gaugeReportersMap[CountServers].WithLabelValues("")))
+		mismatch := p.countReportersMap[LabelMismatch]
+		assert.Equal(t, float64(1), testutil.ToFloat64(mismatch.WithLabelValues(CountServers, "missing")))
+	})
+
+	t.Run("mapping", func(t *testing.T) {
+		p, _ := newTestPrometheusReporter(t, nil, WithLabelMapping(map[string]string{"serverKind": "type"}))
+
+		assert.NoError(t, p.ReportGauge(CountServers, map[string]string{"serverKind": "connector"}, 2))
+		assert.Equal(t, float64(2), testutil.ToFloat64(p.gaugeReportersMap[CountServers].WithLabelValues("connector")))
+	})
+
+	t.Run("reject-unknown", func(t *testing.T) {
+		p, _ := newTestPrometheusReporter(t, nil, WithUnknownLabelPolicy(RejectUnknownLabels))
+
+		err := p.ReportGauge(CountServers, map[string]string{"type": "connector", "zone": "b", "az": "1"}, 2)
+		assert.True(t, errors.Is(err, ErrLabelMismatch))
+		assert.EqualError(t, err, ErrLabelMismatch.Error()+": "+CountServers+" does not declare az, zone")
+	})
+}
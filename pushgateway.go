@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"context"
+	logger "github.com/gotechbook/gotechbook-framework-logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"sync"
+	"time"
+)
+
+type pushOptions struct {
+	url            string
+	job            string
+	interval       time.Duration
+	deleteOnExit   bool
+	client         push.HTTPDoer
+	groupingLabels map[string]string
+}
+
+// pushLoop pushes the reporter metrics to a Pushgateway until stopped
+type pushLoop struct {
+	pusher       *push.Pusher
+	interval     time.Duration
+	deleteOnExit bool
+	stop         chan struct{}
+	done         chan struct{}
+	once         sync.Once
+}
+
+// WithPushgateway pushes the gathered metrics to the Pushgateway at url under
+// job, grouped by serverType and the const tags, every WithPushInterval and
+// on Shutdown. It is meant for workers that may not live long enough to be
+// scraped
+func WithPushgateway(url, job string) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.push.url = url
+		o.push.job = job
+	}
+}
+
+// WithPushInterval sets how often metrics are pushed, 15s by default
+func WithPushInterval(interval time.Duration) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.push.interval = interval
+	}
+}
+
+// WithPushDeleteOnExit deletes the grouping key from the Pushgateway on
+// Shutdown instead of pushing the last values, so the metrics of a finished
+// worker do not linger
+func WithPushDeleteOnExit() PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.push.deleteOnExit = true
+	}
+}
+
+// WithPushClient pushes with client instead of http.DefaultClient
+func WithPushClient(client push.HTTPDoer) PrometheusOption {
+	return func(o *prometheusOptions) {
+		o.push.client = client
+	}
+}
+
+func newPushLoop(o pushOptions, gatherer prometheus.Gatherer) *pushLoop {
+	pusher := push.New(o.url, o.job).Gatherer(&groupingGatherer{gatherer: gatherer, grouping: o.groupingLabels})
+	for name, value := range o.groupingLabels {
+		pusher = pusher.Grouping(name, value)
+	}
+	if o.client != nil {
+		pusher = pusher.Client(o.client)
+	}
+	if o.interval <= 0 {
+		o.interval = 15 * time.Second
+	}
+	l := &pushLoop{
+		pusher:       pusher,
+		interval:     o.interval,
+		deleteOnExit: o.deleteOnExit,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+func (l *pushLoop) run() {
+	defer close(l.done)
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			if err := l.pusher.Push(); err != nil {
+				logger.Log.Errorf("failed to push metrics: %q", err)
+			}
+		}
+	}
+}
+
+// halt stops the loop without pushing
+func (l *pushLoop) halt() {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+}
+
+// shutdown stops the loop and pushes the last values or deletes the group
+func (l *pushLoop) shutdown(ctx context.Context) error {
+	l.halt()
+	select {
+	case <-l.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if l.deleteOnExit {
+		return l.pusher.Delete()
+	}
+	return l.pusher.PushContext(ctx)
+}
+
+// Push sends the current values to the Pushgateway configured with
+// WithPushgateway, use it when a worker finishes a batch of jobs
+func (p *PrometheusReporter) Push(ctx context.Context) error {
+	if p.push == nil {
+		return nil
+	}
+	return p.push.pusher.PushContext(ctx)
+}
+
+// groupingGatherer drops the labels the Pushgateway adds back from the
+// grouping key, the push client refuses metrics carrying them
+type groupingGatherer struct {
+	gatherer prometheus.Gatherer
+	grouping map[string]string
+}
+
+func (g *groupingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.gatherer.Gather()
+	for _, mf := range mfs {
+		for _, m := range mf.Metric {
+			labels := m.Label[:0:0]
+			for _, l := range m.Label {
+				if v, ok := g.grouping[l.GetName()]; ok && v == l.GetValue() {
+					continue
+				}
+				labels = append(labels, l)
+			}
+			m.Label = labels
+		}
+	}
+	return mfs, err
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type pushedRequest struct {
+	method string
+	path   string
+	body   string
+}
+
+// pushgateway stands in for a Pushgateway, keeping every request
+type pushgateway struct {
+	mutex    sync.Mutex
+	requests []pushedRequest
+}
+
+func (g *pushgateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// keep the pushed families in the text format to ease assertions
+	var body strings.Builder
+	dec := expfmt.NewDecoder(r.Body, expfmt.ResponseFormat(r.Header))
+	enc := expfmt.NewEncoder(&body, expfmt.FmtText)
+	for {
+		mf := &dto.MetricFamily{}
+		if err := dec.Decode(mf); err != nil {
+			break
+		}
+		enc.Encode(mf)
+	}
+	g.mutex.Lock()
+	g.requests = append(g.requests, pushedRequest{method: r.Method, path: r.URL.EscapedPath(), body: body.String()})
+	g.mutex.Unlock()
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// grouping returns the labels encoded in a push path, in any order
+func (r pushedRequest) grouping() map[string]string {
+	parts := strings.Split(strings.TrimPrefix(r.path, "/metrics/"), "/")
+	grouping := map[string]string{}
+	for i := 0; i+1 < len(parts); i += 2 {
+		grouping[parts[i]] = parts[i+1]
+	}
+	return grouping
+}
+
+func (g *pushgateway) received() []pushedRequest {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return append([]pushedRequest(nil), g.requests...)
+}
+
+func newPushingReporter(t *testing.T, url string, opts ...PrometheusOption) *PrometheusReporter {
+	t.Helper()
+	opts = append([]PrometheusOption{WithRegistry(prometheus.NewRegistry()), WithoutServer(), WithPushgateway(url, "jobs")}, opts...)
+	p, err := NewPrometheusReporter("worker", config.Metrics{
+		GoTechBookFrameworkMetricsConstTags: map[string]string{"region": "us-east"},
+	}, nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPrometheusReporterPushgateway(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	p := newPushingReporter(t, server.URL, WithPushInterval(10*time.Millisecond))
+	assert.NoError(t, p.ReportGauge(WorkerJobsTotal, map[string]string{"status": "ok"}, 3))
+	assert.Eventually(t, func() bool {
+		return len(gateway.received()) > 0
+	}, time.Second, 5*time.Millisecond, "periodic push")
+	assert.NoError(t, p.ReportGauge(WorkerJobsTotal, map[string]string{"status": "ok"}, 4))
+	assert.NoError(t, p.Shutdown(context.Background()))
+
+	requests := gateway.received()
+	last := requests[len(requests)-1]
+	assert.Equal(t, http.MethodPut, last.method)
+	assert.Equal(t, map[string]string{"job": "jobs", "serverType": "worker", "region": "us-east"}, last.grouping())
+	assert.Contains(t, last.body, `gotechbook_worker_worker_jobs_total{game="",status="ok"} 4`)
+	assert.NotContains(t, last.body, "serverType=")
+}
+
+func TestPrometheusReporterPushgatewayDeleteOnExit(t *testing.T) {
+	gateway := &pushgateway{}
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	p := newPushingReporter(t, server.URL, WithPushInterval(time.Hour), WithPushDeleteOnExit())
+	assert.NoError(t, p.ReportGauge(WorkerQueueSize, map[string]string{"queue": "mail"}, 1))
+	assert.NoError(t, p.Push(context.Background()))
+	assert.NoError(t, p.Shutdown(context.Background()))
+
+	requests := gateway.received()
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, http.MethodPut, requests[0].method)
+		assert.Contains(t, requests[0].body, `gotechbook_worker_worker_queue_size{game="",queue="mail"} 1`)
+		assert.Equal(t, http.MethodDelete, requests[1].method)
+		assert.Equal(t, requests[0].grouping(), requests[1].grouping())
+	}
+}
@@ -0,0 +1,423 @@
+package metrics
+
+import (
+	"fmt"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func (k sampleKind) String() string {
+	switch k {
+	case countSample:
+		return "counter"
+	case summarySample:
+		return "summary"
+	case histogramSample:
+		return "histogram"
+	case gaugeSample:
+		return "gauge"
+	}
+	return "unknown"
+}
+
+// metricKey identifies a declared metric, the same name may be declared once
+// per kind as the built-in ResponseTime summary and histogram are
+type metricKey struct {
+	kind sampleKind
+	name string
+}
+
+// customMetric is the definition of a metric declared by the
+// CustomMetricsSpec or registered at runtime
+type customMetric struct {
+	kind       sampleKind
+	subsystem  string
+	name       string
+	help       string
+	labels     []string
+	buckets    []float64
+	objectives map[float64]float64
+}
+
+func counterMetric(c *config.Counter) *customMetric {
+	return &customMetric{kind: countSample, subsystem: c.Subsystem, name: c.Name, help: c.Help, labels: c.Labels}
+}
+
+func gaugeMetric(g *config.Gauge) *customMetric {
+	return &customMetric{kind: gaugeSample, subsystem: g.Subsystem, name: g.Name, help: g.Help, labels: g.Labels}
+}
+
+func summaryMetric(s *config.Summary) *customMetric {
+	return &customMetric{kind: summarySample, subsystem: s.Subsystem, name: s.Name, help: s.Help, labels: s.Labels, objectives: s.Objectives}
+}
+
+func histogramMetric(h *config.Histogram) *customMetric {
+	return &customMetric{kind: histogramSample, subsystem: h.Subsystem, name: h.Name, help: h.Help, labels: h.Labels, buckets: h.Buckets}
+}
+
+// customMetricsFromSpec lists the definitions of spec, summaries, histograms,
+// gauges then counters, each in declaration order
+func customMetricsFromSpec(spec *config.CustomMetricsSpec) []*customMetric {
+	var defs []*customMetric
+	for _, s := range spec.Summaries {
+		if s != nil {
+			defs = append(defs, summaryMetric(s))
+		}
+	}
+	for _, h := range spec.Histograms {
+		if h != nil {
+			defs = append(defs, histogramMetric(h))
+		}
+	}
+	for _, g := range spec.Gauges {
+		if g != nil {
+			defs = append(defs, gaugeMetric(g))
+		}
+	}
+	for _, c := range spec.Counters {
+		if c != nil {
+			defs = append(defs, counterMetric(c))
+		}
+	}
+	return defs
+}
+
+func (m *customMetric) key() metricKey {
+	return metricKey{kind: m.kind, name: m.name}
+}
+
+func (m *customMetric) fqName() string {
+	return prometheus.BuildFQName(config.PREFIX, m.subsystem, m.name)
+}
+
+// validate rejects the definitions the prometheus vectors would panic on
+func (m *customMetric) validate() error {
+	if m.name == "" {
+		return fmt.Errorf("%w: %s without a name", ErrInvalidMetric, m.kind)
+	}
+	for _, label := range m.labels {
+		if (m.kind == histogramSample && label == "le") || (m.kind == summarySample && label == "quantile") {
+			return fmt.Errorf("%w: %s %s declares the reserved label %s", ErrInvalidMetric, m.kind, m.name, label)
+		}
+	}
+	for i := 1; i < len(m.buckets); i++ {
+		if m.buckets[i] <= m.buckets[i-1] {
+			return fmt.Errorf("%w: histogram %s buckets are not strictly increasing", ErrInvalidMetric, m.name)
+		}
+	}
+	for q, e := range m.objectives {
+		if q < 0 || q > 1 || e < 0 || e > 1 {
+			return fmt.Errorf("%w: summary %s objective %v: %v is out of [0, 1]", ErrInvalidMetric, m.name, q, e)
+		}
+	}
+	return nil
+}
+
+// sameBuckets tells whether both definitions observe into the same buckets
+// or objectives, the only settings a vector can not change once created
+func (m *customMetric) sameBuckets(other *customMetric) bool {
+	if len(m.buckets) != len(other.buckets) || len(m.objectives) != len(other.objectives) {
+		return false
+	}
+	for i := range m.buckets {
+		if m.buckets[i] != other.buckets[i] {
+			return false
+		}
+	}
+	for q, e := range m.objectives {
+		if oe, ok := other.objectives[q]; !ok || oe != e {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *customMetric) equal(other *customMetric) bool {
+	if m.kind != other.kind || m.subsystem != other.subsystem || m.name != other.name || m.help != other.help {
+		return false
+	}
+	if len(m.labels) != len(other.labels) {
+		return false
+	}
+	for i := range m.labels {
+		if m.labels[i] != other.labels[i] {
+			return false
+		}
+	}
+	return m.sameBuckets(other)
+}
+
+// RegisterCounter declares a counter after the reporter was built. Declaring
+// the very same counter again is a no-op, declaring another one under the
+// same name fails with ErrMetricConflict
+func (p *PrometheusReporter) RegisterCounter(counter *config.Counter) error {
+	return p.register(counterMetric(counter))
+}
+
+// RegisterGauge declares a gauge after the reporter was built, see RegisterCounter
+func (p *PrometheusReporter) RegisterGauge(gauge *config.Gauge) error {
+	return p.register(gaugeMetric(gauge))
+}
+
+// RegisterSummary declares a summary after the reporter was built, see RegisterCounter
+func (p *PrometheusReporter) RegisterSummary(summary *config.Summary) error {
+	return p.register(summaryMetric(summary))
+}
+
+// RegisterHistogram declares a histogram after the reporter was built, see RegisterCounter
+func (p *PrometheusReporter) RegisterHistogram(histogram *config.Histogram) error {
+	return p.register(histogramMetric(histogram))
+}
+
+// Unregister removes every custom metric declared under metric, whatever its
+// kind, and its series. Handles taken on it before keep observing into the
+// removed vector. Built-in metrics can not be unregistered
+func (p *PrometheusReporter) Unregister(metric string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	found := false
+	for _, kind := range []sampleKind{countSample, summarySample, histogramSample, gaugeSample} {
+		key := metricKey{kind: kind, name: metric}
+		if _, ok := p.custom[key]; ok {
+			p.undeclare(key)
+			found = true
+		}
+	}
+	if found {
+		return nil
+	}
+	if p.declared(metric) {
+		return fmt.Errorf("%w: %s", ErrBuiltinMetric, metric)
+	}
+	return ErrMetricNotKnown
+}
+
+func (p *PrometheusReporter) register(def *customMetric) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	exists, err := p.checkCustom(def)
+	if err != nil || exists {
+		return err
+	}
+	return p.declareCustom(def, p.newCustomVector(def))
+}
+
+// checkCustom validates def against the metrics already declared, exists is
+// true when the very same definition is already declared
+func (p *PrometheusReporter) checkCustom(def *customMetric) (exists bool, err error) {
+	if err := def.validate(); err != nil {
+		return false, err
+	}
+	if current, ok := p.custom[def.key()]; ok {
+		if current.equal(def) {
+			return true, nil
+		}
+		return false, fmt.Errorf("%w: %s %s is already declared with another definition", ErrMetricConflict, def.kind, def.name)
+	}
+	if p.vector(def.kind, def.name) != nil {
+		return false, fmt.Errorf("%w: %s %s is a built-in metric", ErrMetricConflict, def.kind, def.name)
+	}
+	if key, ok := p.fqNames[def.fqName()]; ok {
+		return false, fmt.Errorf("%w: %s is already exposed by the %s %s", ErrMetricConflict, def.fqName(), key.kind, key.name)
+	}
+	return false, nil
+}
+
+// declared tells whether a metric of any kind is declared under name
+func (p *PrometheusReporter) declared(name string) bool {
+	for _, kind := range []sampleKind{countSample, summarySample, histogramSample, gaugeSample} {
+		if p.vector(kind, name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// vector returns the vector declared for kind under name, or nil
+func (p *PrometheusReporter) vector(kind sampleKind, name string) prometheus.Collector {
+	switch kind {
+	case countSample:
+		if vec, ok := p.countReportersMap[name]; ok {
+			return vec
+		}
+	case summarySample:
+		if vec, ok := p.summaryReportersMap[name]; ok {
+			return vec
+		}
+	case histogramSample:
+		if vec, ok := p.histogramReportersMap[name]; ok {
+			return vec
+		}
+	case gaugeSample:
+		if vec, ok := p.gaugeReportersMap[name]; ok {
+			return vec
+		}
+	}
+	return nil
+}
+
+// labelNames returns the label names of def, the additional labels first as
+// every custom metric always had
+func (p *PrometheusReporter) labelNames(def *customMetric) []string {
+	labelNames := make([]string, 0, len(p.additionalLabelsKeys)+len(def.labels))
+	return append(append(labelNames, p.additionalLabelsKeys...), def.labels...)
+}
+
+// newCustomVector builds the vector of def
+func (p *PrometheusReporter) newCustomVector(def *customMetric) prometheus.Collector {
+	labelNames := p.labelNames(def)
+	switch def.kind {
+	case countSample:
+		return prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   def.subsystem,
+			Name:        def.name,
+			Help:        def.help,
+			ConstLabels: p.constLabels,
+		}, labelNames)
+	case summarySample:
+		return prometheus.NewSummaryVec(prometheus.SummaryOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   def.subsystem,
+			Name:        def.name,
+			Help:        def.help,
+			Objectives:  def.objectives,
+			ConstLabels: p.constLabels,
+		}, labelNames)
+	case histogramSample:
+		return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace:   config.PREFIX,
+			Subsystem:   def.subsystem,
+			Name:        def.name,
+			Help:        def.help,
+			Buckets:     def.buckets,
+			ConstLabels: p.constLabels,
+		}, labelNames)
+	}
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace:   config.PREFIX,
+		Subsystem:   def.subsystem,
+		Name:        def.name,
+		Help:        def.help,
+		ConstLabels: p.constLabels,
+	}, labelNames)
+}
+
+// declareCustom registers vec, built by newCustomVector, and makes it the
+// vector reports of def go to
+func (p *PrometheusReporter) declareCustom(def *customMetric, vec prometheus.Collector) error {
+	if err := p.expose(def, vec); err != nil {
+		return err
+	}
+	labelNames := p.labelNames(def)
+	switch v := vec.(type) {
+	case *prometheus.CounterVec:
+		p.countReportersMap[def.name] = v
+		p.countLabels[def.name] = labelNames
+	case *prometheus.SummaryVec:
+		p.summaryReportersMap[def.name] = v
+		p.summaryLabels[def.name] = labelNames
+	case *prometheus.HistogramVec:
+		p.histogramReportersMap[def.name] = v
+		p.histogramLabels[def.name] = labelNames
+	case *prometheus.GaugeVec:
+		p.gaugeReportersMap[def.name] = v
+		p.gaugeLabels[def.name] = labelNames
+	}
+	p.custom[def.key()] = def
+	p.fqNames[def.fqName()] = def.key()
+	return nil
+}
+
+// expose registers vec as a checked collector, so a name taken by another
+// collector of the registry is rejected right away. The registry pins the
+// help and label names of every name for its lifetime: a name this reporter
+// exposed before is collected by customCollector instead when the checked
+// registration fails
+func (p *PrometheusReporter) expose(def *customMetric, vec prometheus.Collector) error {
+	err := p.registerer.Register(vec)
+	if err == nil {
+		p.exposed[def.fqName()] = true
+		return nil
+	}
+	if !p.exposed[def.fqName()] {
+		return fmt.Errorf("%w: %s %s: %s", ErrMetricConflict, def.kind, def.name, err)
+	}
+	return p.exposeUnchecked(def.key())
+}
+
+// exposeUnchecked moves the vector of key, already declared or being
+// declared, to customCollector
+func (p *PrometheusReporter) exposeUnchecked(key metricKey) error {
+	if p.unchecked[key] {
+		return nil
+	}
+	if !p.uncheckedRegistered {
+		if err := p.registerer.Register(customCollector{p: p}); err != nil {
+			return err
+		}
+		p.uncheckedRegistered = true
+	}
+	if vec := p.vector(key.kind, key.name); vec != nil {
+		p.registerer.Unregister(vec)
+	}
+	p.unchecked[key] = true
+	return nil
+}
+
+func (p *PrometheusReporter) undeclare(key metricKey) {
+	if p.unchecked[key] {
+		delete(p.unchecked, key)
+	} else if vec := p.vector(key.kind, key.name); vec != nil {
+		p.registerer.Unregister(vec)
+	}
+	switch key.kind {
+	case countSample:
+		delete(p.countReportersMap, key.name)
+		delete(p.countLabels, key.name)
+	case summarySample:
+		delete(p.summaryReportersMap, key.name)
+		delete(p.summaryLabels, key.name)
+	case histogramSample:
+		delete(p.histogramReportersMap, key.name)
+		delete(p.histogramLabels, key.name)
+	case gaugeSample:
+		delete(p.gaugeReportersMap, key.name)
+		delete(p.gaugeLabels, key.name)
+	}
+	delete(p.fqNames, p.custom[key].fqName())
+	delete(p.custom, key)
+	delete(p.specMetrics, key)
+	delete(p.helps, key)
+	if p.cardinality != nil && !p.declared(key.name) {
+		p.cardinality.forget(key.name)
+	}
+}
+
+// customCollector exposes, as an unchecked collector, the custom vectors
+// declared again under a name the registry pinned to another help or other
+// label names, and the ones whose help was reloaded. It is registered the
+// first time such a vector is declared
+type customCollector struct {
+	p *PrometheusReporter
+}
+
+func (c customCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c customCollector) Collect(ch chan<- prometheus.Metric) {
+	c.p.mu.RLock()
+	vectors := make([]prometheus.Collector, 0, len(c.p.unchecked))
+	descs := make([]*prometheus.Desc, 0, len(c.p.unchecked))
+	for key := range c.p.unchecked {
+		vectors = append(vectors, c.p.vector(key.kind, key.name))
+		descs = append(descs, c.p.helps[key])
+	}
+	c.p.mu.RUnlock()
+	for i, vec := range vectors {
+		if descs[i] != nil {
+			collectDescribed(vec, descs[i], ch)
+			continue
+		}
+		vec.Collect(ch)
+	}
+}
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/golang/mock/gomock"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPrometheusReporterRegister(t *testing.T) {
+	p, registry := newTestPrometheusReporter(t, nil)
+
+	assert.Equal(t, ErrMetricNotKnown, p.ReportCount("joins", map[string]string{"room": "lobby"}, 1))
+	assert.NoError(t, p.RegisterCounter(&config.Counter{Subsystem: "room", Name: "joins", Help: "the room joins", Labels: []string{"room"}}))
+	assert.NoError(t, p.RegisterGauge(&config.Gauge{Subsystem: "room", Name: "players", Help: "the room players"}))
+	assert.NoError(t, p.RegisterSummary(&config.Summary{Subsystem: "room", Name: "wait", Help: "the room wait", Objectives: map[float64]float64{0.5: 0.05}}))
+	assert.NoError(t, p.RegisterHistogram(&config.Histogram{Subsystem: "room", Name: "size", Help: "the room size", Buckets: []float64{2, 4}}))
+
+	assert.NoError(t, p.ReportCount("joins", map[string]string{"room": "lobby"}, 2))
+	assert.NoError(t, p.ReportGauge("players", map[string]string{}, 5))
+	assert.NoError(t, p.ReportSummary("wait", map[string]string{}, 1))
+	assert.NoError(t, p.ReportHistogram("size", map[string]string{}, 3))
+
+	expected := `
+# HELP gotechbook_room_joins the room joins
+# TYPE gotechbook_room_joins counter
+gotechbook_room_joins{game="",room="lobby",serverType="game"} 2
+# HELP gotechbook_room_players the room players
+# TYPE gotechbook_room_players gauge
+gotechbook_room_players{game="",serverType="game"} 5
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "gotechbook_room_joins", "gotechbook_room_players"))
+	count, err := testutil.GatherAndCount(registry, "gotechbook_room_wait", "gotechbook_room_size")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	t.Run("the same definition again is a no-op", func(t *testing.T) {
+		assert.NoError(t, p.RegisterCounter(&config.Counter{Subsystem: "room", Name: "joins", Help: "the room joins", Labels: []string{"room"}}))
+		assert.Equal(t, float64(2), testutil.ToFloat64(p.countReportersMap["joins"]))
+	})
+
+	t.Run("conflicting definitions", func(t *testing.T) {
+		assert.True(t, errors.Is(p.RegisterCounter(&config.Counter{Subsystem: "room", Name: "joins", Help: "the room joins", Labels: []string{"mode"}}), ErrMetricConflict))
+		assert.True(t, errors.Is(p.RegisterHistogram(&config.Histogram{Subsystem: "room", Name: "size", Help: "the room size", Buckets: []float64{2, 8}}), ErrMetricConflict))
+		assert.True(t, errors.Is(p.RegisterGauge(&config.Gauge{Name: ConnectedClients, Help: "clients"}), ErrMetricConflict))
+		assert.True(t, errors.Is(p.RegisterSummary(&config.Summary{Subsystem: "handler", Name: ResponseTime, Help: "response"}), ErrMetricConflict))
+		assert.True(t, errors.Is(p.RegisterGauge(&config.Gauge{Subsystem: "room", Name: "joins", Help: "the room joins"}), ErrMetricConflict))
+		assert.Equal(t, ErrMetricNotKnown, p.ReportGauge("joins", map[string]string{}, 1))
+	})
+
+	t.Run("invalid definitions", func(t *testing.T) {
+		assert.True(t, errors.Is(p.RegisterCounter(&config.Counter{Help: "nameless"}), ErrInvalidMetric))
+		assert.True(t, errors.Is(p.RegisterHistogram(&config.Histogram{Name: "unsorted", Help: "unsorted", Buckets: []float64{4, 2}}), ErrInvalidMetric))
+		assert.True(t, errors.Is(p.RegisterHistogram(&config.Histogram{Name: "le", Help: "le", Labels: []string{"le"}}), ErrInvalidMetric))
+		assert.True(t, errors.Is(p.RegisterSummary(&config.Summary{Name: "quantile", Help: "quantile", Labels: []string{"quantile"}}), ErrInvalidMetric))
+		assert.True(t, errors.Is(p.RegisterSummary(&config.Summary{Name: "objective", Help: "objective", Objectives: map[float64]float64{1.5: 0.1}}), ErrInvalidMetric))
+	})
+
+	t.Run("unregister", func(t *testing.T) {
+		assert.NoError(t, p.Unregister("joins"))
+		assert.Equal(t, ErrMetricNotKnown, p.ReportCount("joins", map[string]string{"room": "lobby"}, 1))
+		count, err := testutil.GatherAndCount(registry, "gotechbook_room_joins")
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+
+		assert.Equal(t, ErrMetricNotKnown, p.Unregister("joins"))
+		assert.True(t, errors.Is(p.Unregister(ConnectedClients), ErrBuiltinMetric))
+
+		assert.NoError(t, p.RegisterCounter(&config.Counter{Subsystem: "room", Name: "joins", Help: "the room joins by mode", Labels: []string{"mode"}}))
+		assert.NoError(t, p.ReportCount("joins", map[string]string{"mode": "duel"}, 1))
+	})
+}
+
+func TestPrometheusReporterRegisterWhileReporting(t *testing.T) {
+	p, registry := newTestPrometheusReporter(t, nil)
+	handle, err := p.Gauge(ConnectedClients)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				p.ReportCount("dynamic_0", map[string]string{}, 1)
+				p.ReportCount(ExceededRateLimiting, map[string]string{}, 1)
+				handle.With().Set(1)
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("dynamic_%d", i%5)
+		assert.NoError(t, p.RegisterCounter(&config.Counter{Name: name, Help: "a dynamic counter"}))
+		if i%2 == 1 {
+			assert.NoError(t, p.Unregister(name))
+		}
+		_, err := registry.Gather()
+		assert.NoError(t, err)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestPrometheusReporterRegisterCollision(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	other := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: "gotechbook", Subsystem: "room", Name: "joins", Help: "another collector"})
+	registry.MustRegister(other)
+
+	spec := &config.CustomMetricsSpec{Counters: []*config.Counter{{Subsystem: "room", Name: "joins", Help: "the room joins"}}}
+	_, err := NewPrometheusReporter("game", config.Metrics{}, spec, WithRegistry(registry), WithoutServer())
+	assert.True(t, errors.Is(err, ErrMetricConflict), err)
+	// the failed reporter left nothing behind
+	assert.True(t, registry.Unregister(other))
+	mfs, err := registry.Gather()
+	assert.NoError(t, err)
+	assert.Empty(t, mfs)
+	registry.MustRegister(other)
+
+	p, err := NewPrometheusReporter("game", config.Metrics{}, nil, WithRegistry(registry), WithoutServer())
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = p.RegisterCounter(&config.Counter{Subsystem: "room", Name: "joins", Help: "the room joins"})
+	assert.True(t, errors.Is(err, ErrMetricConflict), err)
+	assert.Equal(t, ErrMetricNotKnown, p.ReportCount("joins", map[string]string{}, 1))
+	_, err = registry.Gather()
+	assert.NoError(t, err)
+}
+
+func TestNewPrometheusReporterInvalidSpec(t *testing.T) {
+	tables := []struct {
+		name string
+		spec *config.CustomMetricsSpec
+		err  error
+	}{
+		{"unsorted buckets", &config.CustomMetricsSpec{
+			Histograms: []*config.Histogram{{Name: "size", Help: "size", Buckets: []float64{10, 1}}},
+		}, ErrInvalidMetric},
+		{"duplicate", &config.CustomMetricsSpec{
+			Counters: []*config.Counter{{Name: "joins", Help: "joins"}, {Name: "joins", Help: "other joins"}},
+		}, ErrMetricConflict},
+		{"built-in", &config.CustomMetricsSpec{
+			Gauges: []*config.Gauge{{Name: ConnectedClients, Help: "clients"}},
+		}, ErrMetricConflict},
+	}
+	for _, table := range tables {
+		t.Run(table.name, func(t *testing.T) {
+			_, err := NewPrometheusReporter("game", config.Metrics{}, table.spec, WithRegistry(prometheus.NewRegistry()), WithoutServer())
+			assert.True(t, errors.Is(err, table.err), err)
+		})
+	}
+}
+
+func TestRegistrationForwarding(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	client := mocks.NewMockClient(ctrl)
+	client.EXPECT().Count("joins", int64(1), gomock.Any(), gomock.Any())
+	statsd, err := NewStatsdReporter(config.Metrics{}, "game", client)
+	if !assert.NoError(t, err) {
+		return
+	}
+	p, registry := newTestPrometheusReporter(t, nil)
+	multi := NewMultiReporter(map[string]Reporter{"prometheus": NewAsyncReporter(p), "statsd": statsd})
+
+	var registrar Registrar = multi
+	assert.NoError(t, registrar.RegisterCounter(&config.Counter{Name: "joins", Help: "the joins"}))
+	assert.NoError(t, multi.ReportCount("joins", map[string]string{}, 1))
+	assert.NoError(t, multi.Flush())
+	count, err := testutil.GatherAndCount(registry, "gotechbook_joins")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	err = registrar.RegisterCounter(&config.Counter{Name: "joins", Help: "other joins"})
+	var backendErr *BackendError
+	if assert.True(t, errors.As(err, &backendErr)) {
+		assert.Equal(t, "prometheus", backendErr.Backend)
+		assert.True(t, errors.Is(err, ErrMetricConflict))
+	}
+	assert.NoError(t, registrar.Unregister("joins"))
+
+	aggregating := NewAggregatingReporter(statsd)
+	defer aggregating.Shutdown(context.Background())
+	assert.NoError(t, aggregating.RegisterGauge(&config.Gauge{Name: "players", Help: "the players"}))
+}
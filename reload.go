@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	logger "github.com/gotechbook/gotechbook-framework-logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/spf13/viper"
+)
+
+// ReloadSpec makes the custom metrics declared by the CustomMetricsSpec match
+// spec: new metrics are declared, unchanged ones are left intact, metrics
+// missing from spec are removed. A metric whose help alone changed keeps its
+// series. A metric whose labels, subsystem, buckets or objectives changed is
+// replaced by a new vector, which resets its series: counters restart from
+// zero and the histograms and summaries forget their past observations.
+// Handles taken on a replaced metric keep observing into the old vector.
+//
+// Metrics registered with the Register methods are not touched. An invalid
+// spec is rejected as a whole, logged and returned, the current metrics
+// staying as they are. A metric whose name is taken by another collector of
+// the registry is left out, the rest of the spec being applied, and the
+// error returned. Every reload is counted in CustomMetricsReloads
+func (p *PrometheusReporter) ReloadSpec(spec *config.CustomMetricsSpec) error {
+	if spec == nil {
+		spec = &config.CustomMetricsSpec{}
+	}
+	err := p.reloadSpec(spec)
+	status := "ok"
+	if err != nil {
+		status = "failed"
+		logger.Log.Errorf("invalid custom metrics spec, keeping the current metrics: %s", err)
+	}
+	p.ReportCount(CustomMetricsReloads, map[string]string{"status": status}, 1)
+	return err
+}
+
+// OnSpecChange returns a viper config change handler reloading the spec
+// decoded from key, to call from the application own handler
+func (p *PrometheusReporter) OnSpecChange(v *viper.Viper, key string) func(fsnotify.Event) {
+	return func(fsnotify.Event) {
+		spec := &config.CustomMetricsSpec{}
+		if err := v.UnmarshalKey(key, spec); err != nil {
+			logger.Log.Errorf("failed to decode the custom metrics spec %s: %s", key, err)
+			p.ReportCount(CustomMetricsReloads, map[string]string{"status": "failed"}, 1)
+			return
+		}
+		p.ReloadSpec(spec)
+	}
+}
+
+// WatchSpec watches the config file of v and reloads the spec decoded from
+// key on every change. Viper keeps a single change handler, applications
+// having their own should call OnSpecChange from it instead
+func (p *PrometheusReporter) WatchSpec(v *viper.Viper, key string) {
+	v.OnConfigChange(p.OnSpecChange(v, key))
+	v.WatchConfig()
+}
+
+func (p *PrometheusReporter) reloadSpec(spec *config.CustomMetricsSpec) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the names spec can not take: the built-in ones and the ones of the
+	// metrics registered at runtime
+	taken := make(map[string]metricKey, len(p.fqNames))
+	for fqName, key := range p.fqNames {
+		if !p.specMetrics[key] {
+			taken[fqName] = key
+		}
+	}
+	var defs []*customMetric
+	next := make(map[metricKey]*customMetric)
+	for _, def := range customMetricsFromSpec(spec) {
+		if err := def.validate(); err != nil {
+			return err
+		}
+		key := def.key()
+		if declared, ok := next[key]; ok {
+			if !declared.equal(def) {
+				return fmt.Errorf("%w: %s %s is declared twice", ErrMetricConflict, def.kind, def.name)
+			}
+			continue
+		}
+		if current, ok := p.custom[key]; ok && !p.specMetrics[key] && !current.equal(def) {
+			return fmt.Errorf("%w: %s %s is already registered with another definition", ErrMetricConflict, def.kind, def.name)
+		}
+		if _, ok := p.custom[key]; !ok && p.vector(def.kind, def.name) != nil {
+			return fmt.Errorf("%w: %s %s is a built-in metric", ErrMetricConflict, def.kind, def.name)
+		}
+		if owner, ok := taken[def.fqName()]; ok && owner != key {
+			return fmt.Errorf("%w: %s is already exposed by the %s %s", ErrMetricConflict, def.fqName(), owner.kind, owner.name)
+		}
+		taken[def.fqName()] = key
+		next[key] = def
+		defs = append(defs, def)
+	}
+
+	for key := range p.specMetrics {
+		if _, ok := next[key]; !ok {
+			p.undeclare(key)
+		}
+	}
+	var errs []error
+	for _, def := range defs {
+		key := def.key()
+		current, ok := p.custom[key]
+		// a metric registered at runtime and declared the same by spec
+		// stays owned by its registration
+		owned := !ok || p.specMetrics[key]
+		var err error
+		switch {
+		case !ok:
+			err = p.declareCustom(def, p.newCustomVector(def))
+		case current.equal(def):
+		case current.equalButHelp(def):
+			if err = p.exposeUnchecked(key); err == nil {
+				p.custom[key] = def
+				p.helps[key] = prometheus.NewDesc(def.fqName(), def.help, p.labelNames(def), p.constLabels)
+			}
+		default:
+			p.undeclare(key)
+			err = p.declareCustom(def, p.newCustomVector(def))
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if owned {
+			p.specMetrics[key] = true
+		}
+	}
+	return joinErrors(errs...)
+}
+
+// equalButHelp tells whether both definitions only differ by their help
+func (m *customMetric) equalButHelp(other *customMetric) bool {
+	sameHelp := *other
+	sameHelp.help = m.help
+	return m.equal(&sameHelp)
+}
+
+// describedMetric exposes a metric under another description, for vectors
+// whose help was reloaded
+type describedMetric struct {
+	prometheus.Metric
+	desc *prometheus.Desc
+}
+
+func (m describedMetric) Desc() *prometheus.Desc {
+	return m.desc
+}
+
+// collectDescribed collects vec into ch, exposing its metrics under desc
+func collectDescribed(vec prometheus.Collector, desc *prometheus.Desc, ch chan<- prometheus.Metric) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(metrics)
+		close(metrics)
+	}()
+	for m := range metrics {
+		ch <- describedMetric{Metric: m, desc: desc}
+	}
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"errors"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func reloadSpec(helpSuffix string, buckets []float64) *config.CustomMetricsSpec {
+	return &config.CustomMetricsSpec{
+		Counters:   []*config.Counter{{Subsystem: "room", Name: "joins", Help: "the room joins" + helpSuffix}},
+		Histograms: []*config.Histogram{{Subsystem: "room", Name: "size", Help: "the room size", Buckets: buckets}},
+		Summaries:  []*config.Summary{{Subsystem: "room", Name: "wait", Help: "the room wait", Objectives: map[float64]float64{0.5: 0.05}}},
+	}
+}
+
+func TestPrometheusReporterReloadSpec(t *testing.T) {
+	spec := reloadSpec("", []float64{2, 4})
+	spec.Gauges = []*config.Gauge{{Subsystem: "room", Name: "players", Help: "the room players"}}
+	p, registry := newTestPrometheusReporter(t, spec)
+	assert.NoError(t, p.ReportCount("joins", map[string]string{}, 3))
+	assert.NoError(t, p.ReportHistogram("size", map[string]string{}, 3))
+	assert.NoError(t, p.ReportSummary("wait", map[string]string{}, 1))
+	assert.NoError(t, p.ReportGauge("players", map[string]string{}, 5))
+	wait := p.summaryReportersMap["wait"]
+
+	next := reloadSpec(" so far", []float64{1, 10})
+	next.Gauges = []*config.Gauge{{Subsystem: "room", Name: "rooms", Help: "the rooms"}}
+	assert.NoError(t, p.ReloadSpec(next))
+
+	expected := `
+# HELP gotechbook_room_joins the room joins so far
+# TYPE gotechbook_room_joins counter
+gotechbook_room_joins{game="",serverType="game"} 3
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "gotechbook_room_joins"))
+	// the histogram was replaced, the summary left intact
+	count, err := testutil.GatherAndCount(registry, "gotechbook_room_size", "gotechbook_room_wait", "gotechbook_room_players")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Same(t, wait, p.summaryReportersMap["wait"])
+	assert.Equal(t, ErrMetricNotKnown, p.ReportGauge("players", map[string]string{}, 1))
+	assert.NoError(t, p.ReportGauge("rooms", map[string]string{}, 2))
+	assert.NoError(t, p.ReportHistogram("size", map[string]string{}, 3))
+	expected = `
+# HELP gotechbook_room_size the room size
+# TYPE gotechbook_room_size histogram
+gotechbook_room_size_bucket{game="",serverType="game",le="1"} 0
+gotechbook_room_size_bucket{game="",serverType="game",le="10"} 1
+gotechbook_room_size_bucket{game="",serverType="game",le="+Inf"} 1
+gotechbook_room_size_sum{game="",serverType="game"} 3
+gotechbook_room_size_count{game="",serverType="game"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(expected), "gotechbook_room_size"))
+
+	t.Run("invalid spec", func(t *testing.T) {
+		err := p.ReloadSpec(reloadSpec("", []float64{4, 2}))
+		assert.True(t, errors.Is(err, ErrInvalidMetric))
+		assert.NoError(t, p.ReportGauge("rooms", map[string]string{}, 2))
+
+		builtin := &config.CustomMetricsSpec{Gauges: []*config.Gauge{{Subsystem: "acceptor", Name: ConnectedClients, Help: "clients"}}}
+		assert.True(t, errors.Is(p.ReloadSpec(builtin), ErrMetricConflict))
+	})
+
+	t.Run("registered metrics are kept", func(t *testing.T) {
+		assert.NoError(t, p.RegisterCounter(&config.Counter{Name: "module_calls", Help: "the module calls"}))
+		assert.NoError(t, p.ReloadSpec(nil))
+		assert.NoError(t, p.ReportCount("module_calls", map[string]string{}, 1))
+		assert.Equal(t, ErrMetricNotKnown, p.ReportCount("joins", map[string]string{}, 1))
+
+		conflicting := &config.CustomMetricsSpec{Counters: []*config.Counter{{Name: "module_calls", Help: "other calls"}}}
+		assert.True(t, errors.Is(p.ReloadSpec(conflicting), ErrMetricConflict))
+
+		// declaring it the same in spec does not hand it over to spec
+		same := &config.CustomMetricsSpec{Counters: []*config.Counter{{Name: "module_calls", Help: "the module calls"}}}
+		assert.NoError(t, p.ReloadSpec(same))
+		assert.NoError(t, p.ReloadSpec(&config.CustomMetricsSpec{}))
+		assert.NoError(t, p.ReportCount("module_calls", map[string]string{}, 1))
+	})
+
+	reloads := p.countReportersMap[CustomMetricsReloads]
+	assert.Equal(t, float64(4), testutil.ToFloat64(reloads.WithLabelValues("ok")))
+	assert.Equal(t, float64(3), testutil.ToFloat64(reloads.WithLabelValues("failed")))
+}
+
+func TestPrometheusReporterWatchSpec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(`
+custom:
+  summaries:
+    - subsystem: room
+      name: wait
+      help: the room wait
+      objectives:
+        0.5: 0.05
+`)
+	v := viper.New()
+	v.SetConfigFile(path)
+	if !assert.NoError(t, v.ReadInConfig()) {
+		return
+	}
+	spec := &config.CustomMetricsSpec{}
+	assert.NoError(t, v.UnmarshalKey("custom", spec))
+	p, _ := newTestPrometheusReporter(t, spec)
+	assert.NoError(t, p.ReportSummary("wait", map[string]string{}, 1))
+
+	p.WatchSpec(v, "custom")
+	write(`
+custom:
+  summaries:
+    - subsystem: room
+      name: wait
+      help: the room wait
+      objectives:
+        0.5: 0.05
+        0.99: 0.001
+  counters:
+    - subsystem: room
+      name: joins
+      help: the room joins
+      labels: [mode]
+`)
+	deadline := time.Now().Add(5 * time.Second)
+	for p.ReportCount("joins", map[string]string{"mode": "duel"}, 1) != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, p.ReportCount("joins", map[string]string{"mode": "duel"}, 1))
+	p.mu.RLock()
+	objectives := p.custom[metricKey{kind: summarySample, name: "wait"}].objectives
+	p.mu.RUnlock()
+	assert.Equal(t, map[float64]float64{0.5: 0.05, 0.99: 0.001}, objectives)
+}
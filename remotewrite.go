@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/golang/snappy"
+	logger "github.com/gotechbook/gotechbook-framework-logger"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RemoteWriteEndpoint is a Prometheus remote-write receiver
+type RemoteWriteEndpoint struct {
+	URL string
+	// Headers are added to every request sent to URL, e.g. Authorization or
+	// X-Scope-OrgID
+	Headers map[string]string
+}
+
+// RemoteWriter periodically gathers the metrics of a PrometheusReporter and
+// sends them to remote-write endpoints, for clusters where the Prometheus
+// server can not scrape the reporter
+type RemoteWriter struct {
+	gatherer  prometheus.Gatherer
+	endpoints []RemoteWriteEndpoint
+	client    *http.Client
+	interval  time.Duration
+	batchSize int
+	retries   int
+	backoff   time.Duration
+
+	// writeMu serializes the writes so samples reach the endpoints in order
+	writeMu sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// remoteSeries is a remote-write time series with its labels sorted by name
+type remoteSeries struct {
+	labels    []*dto.LabelPair
+	value     float64
+	timestamp int64
+}
+
+// RemoteWriteOption configures a RemoteWriter
+type RemoteWriteOption func(*RemoteWriter)
+
+// WithRemoteWriteInterval sets how often metrics are sent, 15s by default
+func WithRemoteWriteInterval(interval time.Duration) RemoteWriteOption {
+	return func(r *RemoteWriter) {
+		if interval > 0 {
+			r.interval = interval
+		}
+	}
+}
+
+// WithRemoteWriteBatchSize sets the maximum number of series per request, 500
+// by default
+func WithRemoteWriteBatchSize(size int) RemoteWriteOption {
+	return func(r *RemoteWriter) {
+		if size > 0 {
+			r.batchSize = size
+		}
+	}
+}
+
+// WithRemoteWriteRetry sets how many times a failed request is retried and
+// the delay before the first retry, doubled on every attempt. The default is
+// 3 retries starting at 100ms
+func WithRemoteWriteRetry(retries int, backoff time.Duration) RemoteWriteOption {
+	return func(r *RemoteWriter) {
+		r.retries = retries
+		r.backoff = backoff
+	}
+}
+
+// WithRemoteWriteHTTPClient sends with client instead of a client timing out
+// after 10s
+func WithRemoteWriteHTTPClient(client *http.Client) RemoteWriteOption {
+	return func(r *RemoteWriter) {
+		r.client = client
+	}
+}
+
+// NewRemoteWriter starts sending the metrics gathered from the registry of p
+// to endpoints, call Shutdown to send the last values and stop it
+func NewRemoteWriter(p *PrometheusReporter, endpoints []RemoteWriteEndpoint, opts ...RemoteWriteOption) *RemoteWriter {
+	r := &RemoteWriter{
+		gatherer:  p.gatherer,
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		interval:  15 * time.Second,
+		batchSize: 500,
+		retries:   3,
+		backoff:   100 * time.Millisecond,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	go r.loop()
+	return r
+}
+
+func (r *RemoteWriter) loop() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.Write(context.Background()); err != nil {
+				logger.Log.Errorf("failed to remote write metrics: %q", err)
+			}
+		}
+	}
+}
+
+// Write gathers the current values and sends them to every endpoint
+func (r *RemoteWriter) Write(ctx context.Context) error {
+	mfs, err := r.gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	series := remoteSeriesFromFamilies(mfs, time.Now().UnixNano()/int64(time.Millisecond))
+	var payloads [][]byte
+	for start := 0; start < len(series); start += r.batchSize {
+		end := start + r.batchSize
+		if end > len(series) {
+			end = len(series)
+		}
+		payloads = append(payloads, snappy.Encode(nil, encodeWriteRequest(series[start:end])))
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+	var errs []error
+	for _, endpoint := range r.endpoints {
+		for _, payload := range payloads {
+			if err := r.send(ctx, endpoint, payload); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", endpoint.URL, err))
+				break
+			}
+		}
+	}
+	return joinErrors(errs...)
+}
+
+func (r *RemoteWriter) send(ctx context.Context, endpoint RemoteWriteEndpoint, payload []byte) error {
+	backoff := r.backoff
+	var err error
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+		var retry bool
+		retry, err = r.post(ctx, endpoint, payload)
+		if err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post sends payload once, retry tells whether a failure may be transient
+func (r *RemoteWriter) post(ctx context.Context, endpoint RemoteWriteEndpoint, payload []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range endpoint.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+	err = fmt.Errorf("remote write failed with status %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+	return res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests, err
+}
+
+// Shutdown stops the write loop and sends the last values
+func (r *RemoteWriter) Shutdown(ctx context.Context) error {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return r.Write(ctx)
+}
+
+// remoteSeriesFromFamilies flattens mfs into series the way the text
+// exposition does: summaries into quantile, _sum and _count series and
+// histograms into cumulative _bucket, _sum and _count series
+func remoteSeriesFromFamilies(mfs []*dto.MetricFamily, now int64) []remoteSeries {
+	var series []remoteSeries
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.Metric {
+			ts := now
+			if m.TimestampMs != nil {
+				ts = m.GetTimestampMs()
+			}
+			add := func(name string, value float64, extra ...*dto.LabelPair) {
+				series = append(series, remoteSeries{labels: remoteLabels(name, m.Label, extra...), value: value, timestamp: ts})
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add(name, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				add(name, m.GetUntyped().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.Quantile {
+					add(name, q.GetValue(), labelPair("quantile", strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64)))
+				}
+				add(name+"_sum", s.GetSampleSum())
+				add(name+"_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.Bucket {
+					add(name+"_bucket", float64(b.GetCumulativeCount()), labelPair("le", strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64)))
+				}
+				add(name+"_bucket", float64(h.GetSampleCount()), labelPair("le", "+Inf"))
+				add(name+"_sum", h.GetSampleSum())
+				add(name+"_count", float64(h.GetSampleCount()))
+			}
+		}
+	}
+	return series
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// remoteLabels returns __name__, labels and extra sorted by name, as remote
+// write requires, leaving out empty values
+func remoteLabels(name string, labels []*dto.LabelPair, extra ...*dto.LabelPair) []*dto.LabelPair {
+	out := make([]*dto.LabelPair, 0, len(labels)+len(extra)+1)
+	out = append(out, labelPair("__name__", name))
+	for _, l := range append(labels[:len(labels):len(labels)], extra...) {
+		if l.GetValue() != "" {
+			out = append(out, l)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].GetName() < out[j].GetName() })
+	return out
+}
+
+// encodeWriteRequest encodes series as a prometheus.WriteRequest message:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteSeries) []byte {
+	var req, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.GetName())
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.GetValue())
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
@@ -0,0 +1,159 @@
+package metrics
+
+import (
+	"context"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// remoteWriteReceiver stands in for a remote-write endpoint, decoding every
+// WriteRequest into samples keyed by name{label="value",...}
+type remoteWriteReceiver struct {
+	t        *testing.T
+	failures int
+
+	mutex    sync.Mutex
+	requests int
+	headers  []http.Header
+	samples  map[string]float64
+}
+
+func (r *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests++
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		return
+	}
+	r.headers = append(r.headers, req.Header.Clone())
+	compressed, _ := io.ReadAll(req.Body)
+	payload, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.samples == nil {
+		r.samples = map[string]float64{}
+	}
+	r.forEachField(payload, func(_ protowire.Number, ts []byte) {
+		var labels []string
+		var name string
+		var value float64
+		r.forEachField(ts, func(num protowire.Number, msg []byte) {
+			if num == 1 {
+				var k, v string
+				r.forEachField(msg, func(num protowire.Number, b []byte) {
+					if num == 1 {
+						k = string(b)
+					} else {
+						v = string(b)
+					}
+				})
+				if k == "__name__" {
+					name = v
+				} else {
+					labels = append(labels, k+`="`+v+`"`)
+				}
+				return
+			}
+			bits, n := protowire.ConsumeFixed64(msg[1:])
+			assert.Greater(r.t, n, 0)
+			value = math.Float64frombits(bits)
+		})
+		r.samples[name+"{"+strings.Join(labels, ",")+"}"] = value
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// forEachField calls fn with the number and content of every length
+// delimited field of b
+func (r *remoteWriteReceiver) forEachField(b []byte, fn func(protowire.Number, []byte)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		assert.Equal(r.t, protowire.BytesType, typ)
+		b = b[n:]
+		v, n := protowire.ConsumeBytes(b)
+		assert.Greater(r.t, n, 0)
+		fn(num, v)
+		b = b[n:]
+	}
+}
+
+func TestRemoteWriter(t *testing.T) {
+	p, _ := newTestPrometheusReporter(t, nil)
+	assert.NoError(t, p.ReportGauge(ConnectedClients, map[string]string{}, 12))
+	assert.NoError(t, p.ReportCount(ExceededRateLimiting, map[string]string{}, 2))
+	assert.NoError(t, p.ReportHistogram(ResponseTime, map[string]string{
+		"route": "room.join", "status": "ok", "type": "handler", "code": "",
+	}, 2e9))
+
+	first := &remoteWriteReceiver{t: t, failures: 1}
+	second := &remoteWriteReceiver{t: t}
+	firstServer, secondServer := httptest.NewServer(first), httptest.NewServer(second)
+	defer firstServer.Close()
+	defer secondServer.Close()
+
+	w := NewRemoteWriter(p, []RemoteWriteEndpoint{
+		{URL: firstServer.URL, Headers: map[string]string{"X-Scope-OrgID": "game"}},
+		{URL: secondServer.URL, Headers: map[string]string{"Authorization": "Bearer token"}},
+	}, WithRemoteWriteInterval(time.Hour), WithRemoteWriteBatchSize(5), WithRemoteWriteRetry(2, time.Millisecond))
+	assert.NoError(t, w.Shutdown(context.Background()))
+
+	for _, r := range []*remoteWriteReceiver{first, second} {
+		assert.Greater(t, len(r.headers), 1, "series are split in batches")
+		for _, h := range r.headers {
+			assert.Equal(t, "snappy", h.Get("Content-Encoding"))
+			assert.Equal(t, "application/x-protobuf", h.Get("Content-Type"))
+			assert.Equal(t, "0.1.0", h.Get("X-Prometheus-Remote-Write-Version"))
+		}
+		assert.Equal(t, float64(12), r.samples[`gotechbook_acceptor_connected_clients{serverType="game"}`])
+		assert.Equal(t, float64(2), r.samples[`gotechbook_acceptor_exceeded_rate_limiting{serverType="game"}`])
+		histogram := "gotechbook_handler_" + secondsName(ResponseTimeHistogram)
+		labels := `route="room.join",serverType="game",status="ok",type="handler"`
+		assert.Equal(t, float64(1), r.samples[histogram+`_bucket{le="+Inf",`+labels+`}`])
+		assert.Equal(t, float64(0), r.samples[histogram+`_bucket{le="1",`+labels+`}`])
+		assert.Equal(t, float64(1), r.samples[histogram+`_bucket{le="2.5",`+labels+`}`])
+		assert.Equal(t, float64(2), r.samples[histogram+`_sum{`+labels+`}`])
+		assert.Equal(t, float64(1), r.samples[histogram+`_count{`+labels+`}`])
+	}
+	assert.Equal(t, "game", first.headers[0].Get("X-Scope-OrgID"))
+	assert.Empty(t, first.headers[0].Get("Authorization"))
+	assert.Equal(t, "Bearer token", second.headers[0].Get("Authorization"))
+	assert.Equal(t, len(first.headers)+1, first.requests, "the failed batch is retried")
+}
+
+func TestRemoteWriterClientError(t *testing.T) {
+	p, _ := newTestPrometheusReporter(t, nil)
+	assert.NoError(t, p.ReportGauge(ConnectedClients, map[string]string{}, 1))
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	w := NewRemoteWriter(p, []RemoteWriteEndpoint{{URL: server.URL}}, WithRemoteWriteInterval(time.Hour), WithRemoteWriteRetry(3, time.Millisecond))
+	err := w.Shutdown(context.Background())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "out of order sample")
+	assert.Equal(t, 1, requests)
+}
+
+func TestRemoteWriterInvalidOptions(t *testing.T) {
+	p, _ := newTestPrometheusReporter(t, nil)
+	w := NewRemoteWriter(p, nil, WithRemoteWriteInterval(0), WithRemoteWriteBatchSize(-1))
+	assert.Equal(t, 15*time.Second, w.interval)
+	assert.Equal(t, 500, w.batchSize)
+	assert.NoError(t, w.Shutdown(context.Background()))
+}
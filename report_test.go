@@ -5,10 +5,13 @@ import (
 	"errors"
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
+	config "github.com/gotechbook/gotechbook-framework-config"
 	gContext "github.com/gotechbook/gotechbook-framework-context"
 	e "github.com/gotechbook/gotechbook-framework-errors"
 	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
 	"github.com/stretchr/testify/assert"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 )
@@ -158,3 +161,139 @@ func TestReportMessageProcessDelayFromCtx(t *testing.T) {
 		ReportMessageProcessDelayFromCtx(ctx, []Reporter{mockMetricsReporter}, expectedType)
 	})
 }
+
+func newTimingCtx(route string) context.Context {
+	ctx := gContext.AddToPropagateCtx(context.Background(), StartTimeKey, time.Now().UnixNano())
+	return gContext.AddToPropagateCtx(ctx, RouteKey, route)
+}
+
+func TestReportTimingFromCtxMode(t *testing.T) {
+	tables := []struct {
+		mode      TimingReportMode
+		summary   int
+		histogram int
+	}{
+		{TimingSummary, 1, 0},
+		{TimingHistogram, 0, 1},
+		{TimingSummaryAndHistogram, 1, 1},
+	}
+	for _, table := range tables {
+		ctrl := gomock.NewController(t)
+		mockMetricsReporter := mocks.NewMockReporter(ctrl)
+
+		mockMetricsReporter.EXPECT().ReportSummary(ResponseTime, gomock.Any(), gomock.Any()).Times(table.summary)
+		mockMetricsReporter.EXPECT().ReportHistogram(ResponseTime, gomock.Any(), gomock.Any()).Times(table.histogram)
+		ReportTimingFromCtxMode(newTimingCtx("room.join"), []Reporter{mockMetricsReporter}, "handler", nil, table.mode)
+		ctrl.Finish()
+	}
+}
+
+func TestReportSysMetricsContext(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockMetricsReporter := mocks.NewMockReporter(ctrl)
+	mockMetricsReporter.EXPECT().ReportGauge(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	mockMetricsReporter.EXPECT().ReportCount(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ReportSysMetricsContext(ctx, []Reporter{mockMetricsReporter}, time.Millisecond)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ReportSysMetricsContext did not return after cancel")
+	}
+}
+
+func TestReportFromMalformedCtx(t *testing.T) {
+	t.Run("missing-start-time", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockMetricsReporter := mocks.NewMockReporter(ctrl)
+
+		ctx := gContext.AddToPropagateCtx(context.Background(), RouteKey, "room.join")
+		mockMetricsReporter.EXPECT().ReportCount(MalformedContext, map[string]string{"key": StartTimeKey}, float64(1))
+
+		err := ReportTimingFromCtx(ctx, []Reporter{mockMetricsReporter}, "handler", nil)
+		assert.True(t, errors.Is(err, ErrMalformedCtx))
+		assert.EqualError(t, err, StartTimeKey+" is missing from the propagated context")
+	})
+
+	t.Run("missing-route", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockMetricsReporter := mocks.NewMockReporter(ctrl)
+
+		ctx := gContext.AddToPropagateCtx(context.Background(), StartTimeKey, time.Now().UnixNano())
+		mockMetricsReporter.EXPECT().ReportCount(MalformedContext, map[string]string{"key": RouteKey}, float64(1))
+		mockMetricsReporter.EXPECT().ReportSummary(ResponseTime, map[string]string{
+			"route":  UnknownRoute,
+			"status": "ok",
+			"type":   "handler",
+			"code":   "",
+		}, gomock.Any())
+
+		err := ReportTimingFromCtx(ctx, []Reporter{mockMetricsReporter}, "handler", nil)
+		var ctxErr *ContextError
+		assert.True(t, errors.As(err, &ctxErr))
+		assert.Equal(t, RouteKey, ctxErr.Key)
+	})
+
+	t.Run("wrong-types", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockMetricsReporter := mocks.NewMockReporter(ctrl)
+
+		ctx := gContext.AddToPropagateCtx(context.Background(), StartTimeKey, "yesterday")
+		ctx = gContext.AddToPropagateCtx(ctx, RouteKey, 42)
+		mockMetricsReporter.EXPECT().ReportCount(MalformedContext, map[string]string{"key": StartTimeKey}, float64(1))
+		mockMetricsReporter.EXPECT().ReportCount(MalformedContext, map[string]string{"key": RouteKey}, float64(1))
+
+		err := ReportMessageProcessDelayFromCtx(ctx, []Reporter{mockMetricsReporter}, "handler")
+		assert.EqualError(t, err, StartTimeKey+" has unexpected type string in the propagated context; "+
+			RouteKey+" has unexpected type int in the propagated context")
+	})
+}
+
+// tagCheckingClient fails the test when a report carries the tags of another one
+type tagCheckingClient struct {
+	noopClient
+	t        *testing.T
+	expected func(metric string) []string
+}
+
+func (c *tagCheckingClient) Count(metric string, value int64, tags []string, rate float64) error {
+	assert.Equal(c.t, c.expected(metric), tags)
+	return nil
+}
+
+func TestStatsdReporterConcurrentTags(t *testing.T) {
+	for _, pooled := range []bool{false, true} {
+		client := &tagCheckingClient{t: t}
+		sr, err := NewStatsdReporter(config.Metrics{GoTechBookFrameworkMetricsStatsdRate: 1}, "game", client)
+		assert.NoError(t, err)
+		sr.poolTags = pooled
+		// spare capacity lets appending to the default tags share their array
+		sr.defaultTags = append(make([]string, 0, len(sr.defaultTags)+8), sr.defaultTags...)
+		client.expected = func(metric string) []string {
+			return []string{"serverType:game", "worker:" + metric}
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 32; i++ {
+			wg.Add(1)
+			go func(worker string) {
+				defer wg.Done()
+				for j := 0; j < 500; j++ {
+					sr.ReportCount(worker, map[string]string{"worker": worker}, 1)
+				}
+			}(strconv.Itoa(i))
+		}
+		wg.Wait()
+	}
+}
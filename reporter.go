@@ -4,13 +4,34 @@ import (
 	"context"
 	gContext "github.com/gotechbook/gotechbook-framework-context"
 	errors "github.com/gotechbook/gotechbook-framework-errors"
-	"runtime"
 	"time"
 )
 
-func ReportTimingFromCtx(ctx context.Context, reporters []Reporter, typ string, err error) {
+// TimingReportMode selects which metric types ReportTimingFromCtx feeds for ResponseTime
+type TimingReportMode int32
+
+const (
+	// TimingSummary reports ResponseTime through ReportSummary only (default)
+	TimingSummary TimingReportMode = 1 << iota
+	// TimingHistogram reports ResponseTime through ReportHistogram only
+	TimingHistogram
+	// TimingSummaryAndHistogram reports ResponseTime through both
+	TimingSummaryAndHistogram = TimingSummary | TimingHistogram
+)
+
+// ReportTimingFromCtx reports ResponseTime for the request described by ctx.
+// A ctx without a valid StartTimeKey is not timed, one without a valid
+// RouteKey is reported under UnknownRoute, both are counted in
+// MalformedContext and returned as *ContextError
+func ReportTimingFromCtx(ctx context.Context, reporters []Reporter, typ string, err error) error {
+	return ReportTimingFromCtxMode(ctx, reporters, typ, err, TimingSummary)
+}
+
+// ReportTimingFromCtxMode is ReportTimingFromCtx feeding the metric types
+// selected by mode
+func ReportTimingFromCtxMode(ctx context.Context, reporters []Reporter, typ string, err error, mode TimingReportMode) error {
 	if ctx == nil {
-		return
+		return nil
 	}
 	code := errors.GetErrorCode(err)
 	status := "ok"
@@ -18,62 +39,130 @@ func ReportTimingFromCtx(ctx context.Context, reporters []Reporter, typ string,
 		status = "failed"
 	}
 	if len(reporters) > 0 {
-		startTime := gContext.GetFromPropagateCtx(ctx, StartTimeKey)
-		route := gContext.GetFromPropagateCtx(ctx, RouteKey)
-		elapsed := time.Since(time.Unix(0, startTime.(int64)))
+		startTime, route, errs := requestFromCtx(ctx, reporters)
+		if startTime == nil {
+			return joinErrors(errs...)
+		}
+		elapsed := time.Since(*startTime)
 		tags := getTags(ctx, map[string]string{
-			"route":  route.(string),
+			"route":  route,
 			"status": status,
 			"type":   typ,
 			"code":   code,
 		})
 		for _, r := range reporters {
-			r.ReportSummary(ResponseTime, tags, float64(elapsed.Nanoseconds()))
+			if mode&TimingSummary != 0 {
+				errs = append(errs, r.ReportSummary(ResponseTime, tags, float64(elapsed.Nanoseconds())))
+			}
+			if mode&TimingHistogram != 0 {
+				errs = append(errs, r.ReportHistogram(ResponseTime, tags, float64(elapsed.Nanoseconds())))
+			}
 		}
+		return joinErrors(errs...)
 	}
+	return nil
 }
 
-func ReportMessageProcessDelayFromCtx(ctx context.Context, reporters []Reporter, typ string) {
+// ReportMessageProcessDelayFromCtx reports ProcessDelay for the message
+// described by ctx, validating it as ReportTimingFromCtx does
+func ReportMessageProcessDelayFromCtx(ctx context.Context, reporters []Reporter, typ string) error {
+	if ctx == nil {
+		return nil
+	}
 	if len(reporters) > 0 {
-		startTime := gContext.GetFromPropagateCtx(ctx, StartTimeKey)
-		elapsed := time.Since(time.Unix(0, startTime.(int64)))
-		route := gContext.GetFromPropagateCtx(ctx, RouteKey)
+		startTime, route, errs := requestFromCtx(ctx, reporters)
+		if startTime == nil {
+			return joinErrors(errs...)
+		}
+		elapsed := time.Since(*startTime)
 		tags := getTags(ctx, map[string]string{
-			"route": route.(string),
+			"route": route,
 			"type":  typ,
 		})
 		for _, r := range reporters {
-			r.ReportSummary(ProcessDelay, tags, float64(elapsed.Nanoseconds()))
+			errs = append(errs, r.ReportSummary(ProcessDelay, tags, float64(elapsed.Nanoseconds())))
+		}
+		return joinErrors(errs...)
+	}
+	return nil
+}
+
+// requestFromCtx reads the start time and route propagated in ctx, counting
+// every missing or mistyped value in MalformedContext. startTime is nil when
+// it can not be read and route falls back to UnknownRoute
+func requestFromCtx(ctx context.Context, reporters []Reporter) (startTime *time.Time, route string, errs []error) {
+	route = UnknownRoute
+	if val := gContext.GetFromPropagateCtx(ctx, StartTimeKey); val == nil {
+		errs = append(errs, &ContextError{Key: StartTimeKey})
+	} else if ts, ok := val.(int64); !ok {
+		errs = append(errs, &ContextError{Key: StartTimeKey, Value: val})
+	} else {
+		t := time.Unix(0, ts)
+		startTime = &t
+	}
+	if val := gContext.GetFromPropagateCtx(ctx, RouteKey); val == nil {
+		errs = append(errs, &ContextError{Key: RouteKey})
+	} else if r, ok := val.(string); !ok {
+		errs = append(errs, &ContextError{Key: RouteKey, Value: val})
+	} else {
+		route = r
+	}
+
+	for _, err := range errs {
+		for _, r := range reporters {
+			r.ReportCount(MalformedContext, map[string]string{"key": err.(*ContextError).Key}, 1)
 		}
 	}
+	return startTime, route, errs
 }
 
-func ReportNumberOfConnectedClients(reporters []Reporter, number int64) {
+func ReportNumberOfConnectedClients(reporters []Reporter, number int64) error {
+	var errs []error
 	for _, r := range reporters {
-		r.ReportGauge(ConnectedClients, map[string]string{}, float64(number))
+		errs = append(errs, r.ReportGauge(ConnectedClients, map[string]string{}, float64(number)))
 	}
+	return joinErrors(errs...)
 }
 
+// ReportSysMetrics reports the sys metrics every period, forever: shutting
+// down the reporters does not stop it.
+//
+// Deprecated: use ReportSysMetricsContext and cancel its context on shutdown
 func ReportSysMetrics(reporters []Reporter, period time.Duration) {
-	for {
-		for _, r := range reporters {
-			num := runtime.NumGoroutine()
-			m := &runtime.MemStats{}
-			runtime.ReadMemStats(m)
+	ReportSysMetricsContext(context.Background(), reporters, period)
+}
 
-			r.ReportGauge(Goroutines, map[string]string{}, float64(num))
-			r.ReportGauge(HeapSize, map[string]string{}, float64(m.Alloc))
-			r.ReportGauge(HeapObjects, map[string]string{}, float64(m.HeapObjects))
-		}
+// ReportSysMetricsContext is ReportSysMetrics returning once ctx is done
+func ReportSysMetricsContext(ctx context.Context, reporters []Reporter, period time.Duration) {
+	NewSysMetricsCollector(reporters).Run(ctx, period)
+}
+
+// ShutdownReporters flushes and shuts down every reporter implementing Flusher
+// or Closer, returning all the failures joined
+func ShutdownReporters(ctx context.Context, reporters []Reporter) error {
+	var errs []error
+	for _, r := range reporters {
+		errs = append(errs, shutdownReporter(ctx, r))
+	}
+	return joinErrors(errs...)
+}
 
-		time.Sleep(period)
+func shutdownReporter(ctx context.Context, r Reporter) error {
+	if c, ok := r.(Closer); ok {
+		return c.Shutdown(ctx)
+	}
+	if f, ok := r.(Flusher); ok {
+		return f.Flush()
 	}
+	return nil
 }
 
-func ReportExceededRateLimiting(reporters []Reporter) {
+func ReportExceededRateLimiting(reporters []Reporter) error {
+	var errs []error
 	for _, r := range reporters {
-		r.ReportCount(ExceededRateLimiting, map[string]string{}, 1)
+		errs = append(errs, r.ReportCount(ExceededRateLimiting, map[string]string{}, 1))
 	}
+	return joinErrors(errs...)
 }
 
 func tagsFromContext(ctx context.Context) map[string]string {
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"bytes"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"sort"
+)
+
+// Scrape gathers the reporter registry into the text exposition format,
+// families sorted by name and series by label values, so the output of two
+// scrapes of the same values is identical. When names are given only the
+// families with those fully qualified names are kept
+func (p *PrometheusReporter) Scrape(names ...string) ([]byte, error) {
+	mfs, err := p.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool, len(names))
+	for _, name := range names {
+		keep[name] = true
+	}
+	sort.Slice(mfs, func(i, j int) bool { return mfs[i].GetName() < mfs[j].GetName() })
+
+	var buf bytes.Buffer
+	enc := expfmt.NewEncoder(&buf, expfmt.FmtText)
+	for _, mf := range mfs {
+		if len(keep) > 0 && !keep[mf.GetName()] {
+			continue
+		}
+		sort.SliceStable(mf.Metric, func(i, j int) bool {
+			return seriesLess(mf.Metric[i].GetLabel(), mf.Metric[j].GetLabel())
+		})
+		if err := enc.Encode(mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// seriesLess orders label sets by their values, labels being sorted by name
+func seriesLess(a, b []*dto.LabelPair) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].GetName() != b[i].GetName() {
+			return a[i].GetName() < b[i].GetName()
+		}
+		if a[i].GetValue() != b[i].GetValue() {
+			return a[i].GetValue() < b[i].GetValue()
+		}
+	}
+	return len(a) < len(b)
+}
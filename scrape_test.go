@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"flag"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the exposition tests")
+
+// assertGolden compares actual with testdata/name, rewriting the file
+// instead when the tests run with -update
+func assertGolden(t *testing.T, name string, actual []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.MkdirAll("testdata", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, actual, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%s, run the tests with -update to create it", err)
+	}
+	assert.Equal(t, string(expected), string(actual))
+}
+
+func newGoldenPrometheusReporter(t *testing.T, spec *config.CustomMetricsSpec, opts ...PrometheusOption) *PrometheusReporter {
+	t.Helper()
+	opts = append([]PrometheusOption{WithRegistry(prometheus.NewRegistry()), WithoutServer()}, opts...)
+	p, err := NewPrometheusReporter("game", config.Metrics{
+		GoTechBookFrameworkMetricsConstTags:                map[string]string{"region": "us"},
+		GoTechBookFrameworkMetricsPrometheusAdditionalTags: map[string]string{"shard": "default"},
+	}, spec, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// reportEveryMetric reports one sample to every metric declared for a kind,
+// each declared label taking the value <label>-value except the additional
+// labels which keep their default
+func reportEveryMetric(t *testing.T, p *PrometheusReporter, declared map[string][]string, report func(string, map[string]string, float64) error, value float64) {
+	t.Helper()
+	names := make([]string, 0, len(declared))
+	for name := range declared {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		labels := map[string]string{}
+		for _, label := range declared[name] {
+			if _, ok := p.additionalLabels[label]; !ok {
+				labels[label] = label + "-value"
+			}
+		}
+		v := value
+		if unit := MetricUnit(name); unit != UnitNone && unit != UnitBytes {
+			v = convertUnit(value, UnitSeconds, unit)
+		}
+		assert.NoError(t, report(name, labels, v), name)
+	}
+}
+
+func TestPrometheusReporterExpositionBuiltin(t *testing.T) {
+	p := newGoldenPrometheusReporter(t, nil)
+	reportEveryMetric(t, p, p.countLabels, p.ReportCount, 1)
+	reportEveryMetric(t, p, p.gaugeLabels, p.ReportGauge, 2)
+	reportEveryMetric(t, p, p.summaryLabels, p.ReportSummary, 3)
+	reportEveryMetric(t, p, p.histogramLabels, p.ReportHistogram, 3)
+
+	scraped, err := p.Scrape()
+	assert.NoError(t, err)
+	assertGolden(t, "builtin_metrics.golden", scraped)
+}
+
+func TestPrometheusReporterExpositionBuiltinLegacyUnits(t *testing.T) {
+	p := newGoldenPrometheusReporter(t, nil, WithPrometheusLegacyUnits())
+	reportEveryMetric(t, p, p.summaryLabels, p.ReportSummary, 3)
+	reportEveryMetric(t, p, p.histogramLabels, p.ReportHistogram, 3)
+
+	scraped, err := p.Scrape()
+	assert.NoError(t, err)
+	assertGolden(t, "builtin_metrics_legacy_units.golden", scraped)
+}
+
+func TestPrometheusReporterExpositionCustom(t *testing.T) {
+	spec := &config.CustomMetricsSpec{
+		Summaries: []*config.Summary{{
+			Subsystem:  "room",
+			Name:       "match_duration",
+			Help:       "the duration of room matches",
+			Objectives: map[float64]float64{0.5: 0.05, 0.99: 0.001},
+			Labels:     []string{"mode"},
+		}},
+		Histograms: []*config.Histogram{{
+			Subsystem: "room",
+			Name:      "payload_size",
+			Help:      "the size of room payloads",
+			Buckets:   []float64{64, 512, 4096},
+			Labels:    []string{"mode"},
+		}},
+		Gauges: []*config.Gauge{{
+			Subsystem: "room",
+			Name:      "players",
+			Help:      "the players in rooms",
+			Labels:    []string{"mode"},
+		}},
+		Counters: []*config.Counter{{
+			Subsystem: "room",
+			Name:      "created_total",
+			Help:      "the created rooms",
+			Labels:    []string{"mode"},
+		}},
+	}
+	p := newGoldenPrometheusReporter(t, spec)
+	for _, mode := range []string{"duel", "battle"} {
+		labels := map[string]string{"mode": mode}
+		assert.NoError(t, p.ReportSummary("match_duration", labels, 90))
+		assert.NoError(t, p.ReportSummary("match_duration", labels, 120))
+		assert.NoError(t, p.ReportHistogram("payload_size", labels, 100))
+		assert.NoError(t, p.ReportHistogram("payload_size", labels, 5000))
+		assert.NoError(t, p.ReportGauge("players", labels, 8))
+		assert.NoError(t, p.ReportCount("created_total", labels, 3))
+	}
+
+	scraped, err := p.Scrape(
+		"gotechbook_room_match_duration",
+		"gotechbook_room_payload_size",
+		"gotechbook_room_players",
+		"gotechbook_room_created_total",
+	)
+	assert.NoError(t, err)
+	assertGolden(t, "custom_metrics.golden", scraped)
+}
+
+func TestPrometheusReporterScrapeMatchesHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	p := newGoldenPrometheusReporter(t, nil, WithServeMux(mux))
+	assert.NoError(t, p.ReportGauge(ConnectedClients, map[string]string{}, 12))
+	assert.NoError(t, p.ReportCount(ExceededRateLimiting, map[string]string{}, 1))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/metrics")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	scraped, err := p.Scrape("gotechbook_acceptor_connected_clients", "gotechbook_acceptor_exceeded_rate_limiting")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, scraped)
+	assert.Contains(t, string(body), string(scraped))
+}
@@ -1,91 +1,272 @@
 package metrics
 
 import (
-	"fmt"
+	"context"
 	"github.com/DataDog/datadog-go/statsd"
 	config "github.com/gotechbook/gotechbook-framework-config"
 	logger "github.com/gotechbook/gotechbook-framework-logger"
+	"io"
+	"sync"
 )
 
+// HistogramKind selects how StatsdReporter ships histogram samples to DogStatsD
+type HistogramKind int
+
+const (
+	// AgentHistogram aggregates samples on the agent of each host (DogStatsD "h")
+	AgentHistogram HistogramKind = iota
+	// ServerDistribution aggregates samples globally on the Datadog servers (DogStatsD "d")
+	ServerDistribution
+)
+
+// tagsPool recycles the tag slices handed to clients that do not retain them
+var tagsPool = sync.Pool{
+	New: func() interface{} {
+		tags := make([]string, 0, 16)
+		return &tags
+	},
+}
+
 type StatsdReporter struct {
-	client      Client
-	rate        float64
-	serverType  string
-	defaultTags []string
+	client        Client
+	rate          float64
+	serverType    string
+	defaultTags   []string
+	histogramKind HistogramKind
+	// poolTags is only set for the client dialed by the reporter: without
+	// client side aggregation nor channel mode it is done with the tags when
+	// the call returns, injected clients may keep them
+	poolTags    bool
+	legacyUnits bool
+}
+
+// StatsdOption configures a StatsdReporter built by NewStatsdReporterWithOptions
+type StatsdOption func(*StatsdReporter)
+
+// WithStatsdClient makes the reporter use the given client instead of dialing
+// GoTechBookFrameworkMetricsStatsdHost
+func WithStatsdClient(client Client) StatsdOption {
+	return func(s *StatsdReporter) {
+		s.client = client
+	}
+}
+
+// WithHistogramKind chooses between agent-side histograms and server-side
+// distributions for ReportHistogram, the default is AgentHistogram
+func WithHistogramKind(kind HistogramKind) StatsdOption {
+	return func(s *StatsdReporter) {
+		s.histogramKind = kind
+	}
+}
+
+// WithStatsdLegacyUnits sends summary and histogram values unchanged, as the
+// reporter historically did, instead of converting the time ones from their
+// MetricUnit to milliseconds
+func WithStatsdLegacyUnits() StatsdOption {
+	return func(s *StatsdReporter) {
+		s.legacyUnits = true
+	}
 }
 
 func NewStatsdReporter(metrics config.Metrics, serverType string, clientOrNil ...Client) (*StatsdReporter, error) {
+	var opts []StatsdOption
+	if len(clientOrNil) > 0 {
+		opts = append(opts, WithStatsdClient(clientOrNil[0]))
+	}
+	return NewStatsdReporterWithOptions(metrics, serverType, opts...)
+}
+
+func NewStatsdReporterWithOptions(metrics config.Metrics, serverType string, opts ...StatsdOption) (*StatsdReporter, error) {
 	sr := &StatsdReporter{
 		rate:       metrics.GoTechBookFrameworkMetricsStatsdRate,
 		serverType: serverType,
 	}
 	sr.buildDefaultTags(metrics.GoTechBookFrameworkMetricsConstTags)
+	for _, opt := range opts {
+		opt(sr)
+	}
 
-	if len(clientOrNil) > 0 {
-		sr.client = clientOrNil[0]
-	} else {
+	if sr.client == nil {
 		c, err := statsd.New(metrics.GoTechBookFrameworkMetricsStatsdHost)
 		if err != nil {
 			return nil, err
 		}
 		c.Namespace = metrics.GoTechBookFrameworkMetricsStatsdPrefix
 		sr.client = c
+		sr.poolTags = true
 	}
 	return sr, nil
 }
 
 func (s *StatsdReporter) buildDefaultTags(tagsMap map[string]string) {
 	defaultTags := make([]string, len(tagsMap)+1)
-	defaultTags[0] = fmt.Sprintf("serverType:%s", s.serverType)
+	defaultTags[0] = "serverType:" + s.serverType
 	idx := 1
 	for k, v := range tagsMap {
-		defaultTags[idx] = fmt.Sprintf("%s:%s", k, v)
+		defaultTags[idx] = k + ":" + v
 		idx++
 	}
 	s.defaultTags = defaultTags
 }
 
-func (s *StatsdReporter) ReportCount(metric string, tagsMap map[string]string, count float64) error {
-	fullTags := s.defaultTags
+// acquireTags returns the default tags followed by tagsMap encoded as k:v.
+// The slice comes from tagsPool when the client does not keep it after the
+// call returns, buf must then be handed back to releaseTags
+func (s *StatsdReporter) acquireTags(tagsMap map[string]string) (tags []string, buf *[]string) {
+	if s.poolTags {
+		buf = tagsPool.Get().(*[]string)
+		tags = (*buf)[:0]
+	} else {
+		tags = make([]string, 0, len(s.defaultTags)+len(tagsMap))
+	}
+	tags = append(tags, s.defaultTags...)
 	for k, v := range tagsMap {
-		fullTags = append(fullTags, fmt.Sprintf("%s:%s", k, v))
+		tags = append(tags, k+":"+v)
+	}
+	return tags, buf
+}
+
+func (s *StatsdReporter) releaseTags(tags []string, buf *[]string) {
+	if buf == nil {
+		return
+	}
+	for i := range tags {
+		tags[i] = ""
 	}
-	err := s.client.Count(metric, int64(count), fullTags, s.rate)
+	*buf = tags[:0]
+	tagsPool.Put(buf)
+}
+
+func (s *StatsdReporter) ReportCount(metric string, tagsMap map[string]string, count float64) error {
+	tags, buf := s.acquireTags(tagsMap)
+	err := s.client.Count(metric, int64(count), tags, s.rate)
+	s.releaseTags(tags, buf)
 	if err != nil {
-		logger.Log.Errorf("failed to report count: %q", err)
+		s.logError("count", err)
 	}
 	return err
 }
 
 func (s *StatsdReporter) ReportGauge(metric string, tagsMap map[string]string, value float64) error {
-	fullTags := s.defaultTags
-
-	for k, v := range tagsMap {
-		fullTags = append(fullTags, fmt.Sprintf("%s:%s", k, v))
-	}
-
-	err := s.client.Gauge(metric, value, fullTags, s.rate)
+	tags, buf := s.acquireTags(tagsMap)
+	err := s.client.Gauge(metric, value, tags, s.rate)
+	s.releaseTags(tags, buf)
 	if err != nil {
-		logger.Log.Errorf("failed to report gauge: %q", err)
+		s.logError("gauge", err)
 	}
-
 	return err
 }
 
 func (s *StatsdReporter) ReportSummary(metric string, tagsMap map[string]string, value float64) error {
-	fullTags := s.defaultTags
-	for k, v := range tagsMap {
-		fullTags = append(fullTags, fmt.Sprintf("%s:%s", k, v))
+	return s.reportSummary(metric, tagsMap, value, s.rate)
+}
+
+func (s *StatsdReporter) ReportHistogram(metric string, tagsMap map[string]string, value float64) error {
+	return s.reportHistogram(metric, tagsMap, value, s.rate)
+}
+
+// ReportSummaryWeighted sends value once with a sample rate standing for
+// count observations
+func (s *StatsdReporter) ReportSummaryWeighted(metric string, tagsMap map[string]string, value float64, count uint64) error {
+	if count == 0 {
+		return nil
+	}
+	return s.reportSummary(metric, tagsMap, value, s.rate/float64(count))
+}
+
+// ReportHistogramWeighted sends value once with a sample rate standing for
+// count observations
+func (s *StatsdReporter) ReportHistogramWeighted(metric string, tagsMap map[string]string, value float64, count uint64) error {
+	if count == 0 {
+		return nil
 	}
+	return s.reportHistogram(metric, tagsMap, value, s.rate/float64(count))
+}
 
-	err := s.client.TimeInMilliseconds(metric, float64(value), fullTags, s.rate)
+func (s *StatsdReporter) reportSummary(metric string, tagsMap map[string]string, value float64, rate float64) error {
+	if !s.legacyUnits {
+		value = convertUnit(value, MetricUnit(metric), UnitMilliseconds)
+	}
+	tags, buf := s.acquireTags(tagsMap)
+	err := s.client.TimeInMilliseconds(metric, value, tags, rate)
+	s.releaseTags(tags, buf)
 	if err != nil {
-		logger.Log.Errorf("failed to report summary: %q", err)
+		s.logError("summary", err)
 	}
+	return err
+}
 
+func (s *StatsdReporter) reportHistogram(metric string, tagsMap map[string]string, value float64, rate float64) error {
+	if !s.legacyUnits {
+		value = convertUnit(value, MetricUnit(metric), UnitMilliseconds)
+	}
+	tags, buf := s.acquireTags(tagsMap)
+	var err error
+	switch s.histogramKind {
+	case ServerDistribution:
+		err = s.client.Distribution(metric, value, tags, rate)
+	default:
+		err = s.client.Histogram(metric, value, tags, rate)
+	}
+	s.releaseTags(tags, buf)
+	if err != nil {
+		s.logError("histogram", err)
+	}
 	return err
 }
 
-func (s *StatsdReporter) ReportHistogram(metric string, tagsMap map[string]string, value float64) error {
-	return ErrNotImplemented
+// RegisterCounter is a no-op, statsd metrics need no declaration
+func (s *StatsdReporter) RegisterCounter(*config.Counter) error {
+	return nil
+}
+
+// RegisterGauge is a no-op, statsd metrics need no declaration
+func (s *StatsdReporter) RegisterGauge(*config.Gauge) error {
+	return nil
+}
+
+// RegisterSummary is a no-op, statsd metrics need no declaration
+func (s *StatsdReporter) RegisterSummary(*config.Summary) error {
+	return nil
+}
+
+// RegisterHistogram is a no-op, statsd metrics need no declaration
+func (s *StatsdReporter) RegisterHistogram(*config.Histogram) error {
+	return nil
+}
+
+// Unregister is a no-op, the agent expires the metrics no longer reported
+func (s *StatsdReporter) Unregister(string) error {
+	return nil
+}
+
+// Flush sends the datagrams buffered by the client, it is a no-op for clients
+// without buffering
+func (s *StatsdReporter) Flush() error {
+	if f, ok := s.client.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Shutdown flushes and closes the client, giving up when ctx is done
+func (s *StatsdReporter) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		err := s.Flush()
+		if c, ok := s.client.(io.Closer); ok {
+			err = joinErrors(err, c.Close())
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *StatsdReporter) logError(kind string, err error) {
+	logger.Log.Errorf("failed to report %s: %q", kind, err)
 }
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"github.com/golang/mock/gomock"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
+	"github.com/gotechbook/gotechbook-framework-metrics/statsdtest"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsdReporterReportHistogram(t *testing.T) {
+	metrics := config.Metrics{
+		GoTechBookFrameworkMetricsStatsdRate: 1,
+		GoTechBookFrameworkMetricsConstTags:  map[string]string{"region": "us"},
+	}
+	expectedTags := []string{"serverType:game", "region:us", "route:a.b.c"}
+
+	t.Run("agent-histogram", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockClient := mocks.NewMockClient(ctrl)
+
+		sr, err := NewStatsdReporter(metrics, "game", mockClient)
+		assert.NoError(t, err)
+
+		mockClient.EXPECT().Histogram("payload_size", float64(42), expectedTags, float64(1))
+		assert.NoError(t, sr.ReportHistogram("payload_size", map[string]string{"route": "a.b.c"}, 42))
+	})
+
+	t.Run("server-distribution", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockClient := mocks.NewMockClient(ctrl)
+
+		sr, err := NewStatsdReporterWithOptions(metrics, "game", WithStatsdClient(mockClient), WithHistogramKind(ServerDistribution))
+		assert.NoError(t, err)
+
+		mockClient.EXPECT().Distribution("payload_size", float64(42), expectedTags, float64(1))
+		assert.NoError(t, sr.ReportHistogram("payload_size", map[string]string{"route": "a.b.c"}, 42))
+	})
+
+	t.Run("client-error", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+		mockClient := mocks.NewMockClient(ctrl)
+
+		sr, err := NewStatsdReporter(metrics, "game", mockClient)
+		assert.NoError(t, err)
+
+		expectedErr := errors.New("write: connection refused")
+		mockClient.EXPECT().Histogram("payload_size", float64(42), gomock.Any(), float64(1)).Return(expectedErr)
+		assert.Equal(t, expectedErr, sr.ReportHistogram("payload_size", nil, 42))
+	})
+}
+
+type closingClient struct {
+	*mocks.MockClient
+	flushed  int
+	closed   int
+	closeErr error
+}
+
+func (c *closingClient) Flush() error {
+	c.flushed++
+	return nil
+}
+
+func (c *closingClient) Close() error {
+	c.closed++
+	return c.closeErr
+}
+
+func TestStatsdReporterShutdown(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	client := &closingClient{MockClient: mocks.NewMockClient(ctrl), closeErr: errors.New("already closed")}
+
+	sr, err := NewStatsdReporter(config.Metrics{}, "game", client)
+	assert.NoError(t, err)
+
+	assert.NoError(t, sr.Flush())
+	assert.Equal(t, 1, client.flushed)
+
+	err = ShutdownReporters(context.Background(), []Reporter{sr})
+	assert.Equal(t, client.closeErr, err)
+	assert.Equal(t, 2, client.flushed)
+	assert.Equal(t, 1, client.closed)
+}
+
+func TestStatsdReporterEndToEnd(t *testing.T) {
+	servers := map[string]func(t *testing.T) (*statsdtest.Server, error){
+		"udp": func(t *testing.T) (*statsdtest.Server, error) {
+			return statsdtest.NewUDPServer()
+		},
+		"uds": func(t *testing.T) (*statsdtest.Server, error) {
+			return statsdtest.NewUDSServer(filepath.Join(t.TempDir(), "dsd.socket"))
+		},
+	}
+	for name, newServer := range servers {
+		t.Run(name, func(t *testing.T) {
+			server, err := newServer(t)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer server.Close()
+
+			metrics := config.Metrics{
+				GoTechBookFrameworkMetricsStatsdHost:   server.Addr(),
+				GoTechBookFrameworkMetricsStatsdPrefix: "gotechbook.",
+				GoTechBookFrameworkMetricsStatsdRate:   1,
+				GoTechBookFrameworkMetricsConstTags:    map[string]string{"region": "us"},
+			}
+			sr, err := NewStatsdReporter(metrics, "game")
+			if !assert.NoError(t, err) {
+				return
+			}
+			tags := map[string]string{"route": "room.join"}
+			assert.NoError(t, sr.ReportCount(ExceededRateLimiting, tags, 2))
+			assert.NoError(t, sr.ReportGauge(ConnectedClients, tags, 12))
+			assert.NoError(t, sr.ReportSummary(ResponseTime, tags, 2e6))
+			assert.NoError(t, sr.ReportHistogram("payload_size", tags, 42))
+			assert.NoError(t, sr.Shutdown(context.Background()))
+
+			received, ok := server.WaitForMetrics(4, time.Second)
+			assert.True(t, ok)
+			assert.Len(t, received, 4)
+			assert.Empty(t, server.Errors())
+			expectedTags := map[string]string{"serverType": "game", "region": "us", "route": "room.join"}
+			for _, expected := range []struct {
+				name  string
+				typ   string
+				value float64
+			}{
+				{"gotechbook." + ExceededRateLimiting, "c", 2},
+				{"gotechbook." + ConnectedClients, "g", 12},
+				{"gotechbook." + ResponseTime, "ms", 2},
+				{"gotechbook.payload_size", "h", 42},
+			} {
+				if m := server.MetricsNamed(expected.name); assert.Len(t, m, 1, expected.name) {
+					assert.Equal(t, expected.typ, m[0].Type, expected.name)
+					assert.Equal(t, expected.value, m[0].Value(), expected.name)
+					assert.Equal(t, float64(1), m[0].SampleRate, expected.name)
+					assert.Equal(t, expectedTags, m[0].TagMap(), expected.name)
+				}
+			}
+		})
+	}
+}
+
+func TestStatsdReporterEndToEndDistribution(t *testing.T) {
+	server, err := statsdtest.NewUDPServer()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer server.Close()
+
+	sr, err := NewStatsdReporterWithOptions(config.Metrics{
+		GoTechBookFrameworkMetricsStatsdHost: server.Addr(),
+		GoTechBookFrameworkMetricsStatsdRate: 1,
+	}, "game", WithHistogramKind(ServerDistribution))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, sr.ReportHistogram("payload_size", map[string]string{}, 42))
+	assert.NoError(t, sr.Flush())
+
+	received, ok := server.WaitForMetrics(1, time.Second)
+	if assert.True(t, ok) {
+		assert.Equal(t, "payload_size", received[0].Name)
+		assert.Equal(t, "d", received[0].Type)
+		assert.Equal(t, []string{"serverType:game"}, received[0].Tags)
+	}
+	assert.NoError(t, sr.Shutdown(context.Background()))
+}
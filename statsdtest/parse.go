@@ -0,0 +1,214 @@
+package statsdtest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Metric is a DogStatsD metric line: name:value|type|@rate|#tags
+type Metric struct {
+	Name string
+	// Values holds every value of the line, the DogStatsD 1.1 protocol packs
+	// several samples of a metric as name:v1:v2|type
+	Values []float64
+	// Type is the DogStatsD type: c, g, ms, h, d or s
+	Type       string
+	SampleRate float64
+	Tags       []string
+	Container  string
+	Timestamp  int64
+}
+
+// Value returns the first value of the line
+func (m Metric) Value() float64 {
+	if len(m.Values) == 0 {
+		return 0
+	}
+	return m.Values[0]
+}
+
+// TagMap returns the tags as a map, tags without a value map to ""
+func (m Metric) TagMap() map[string]string {
+	return tagMap(m.Tags)
+}
+
+// Event is a DogStatsD event line: _e{title length,text length}:title|text|...
+type Event struct {
+	Title          string
+	Text           string
+	Timestamp      int64
+	Hostname       string
+	AggregationKey string
+	Priority       string
+	SourceType     string
+	AlertType      string
+	Tags           []string
+}
+
+// ServiceCheck is a DogStatsD service check line: _sc|name|status|...
+type ServiceCheck struct {
+	Name      string
+	Status    int
+	Timestamp int64
+	Hostname  string
+	Tags      []string
+	Message   string
+}
+
+// ParseMetric parses a DogStatsD metric line
+func ParseMetric(line string) (Metric, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 2 {
+		return Metric{}, fmt.Errorf("statsdtest: missing metric type in %q", line)
+	}
+	sep := strings.IndexByte(fields[0], ':')
+	if sep <= 0 {
+		return Metric{}, fmt.Errorf("statsdtest: missing metric value in %q", line)
+	}
+	m := Metric{Name: fields[0][:sep], Type: fields[1], SampleRate: 1}
+	for _, raw := range strings.Split(fields[0][sep+1:], ":") {
+		v, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return Metric{}, fmt.Errorf("statsdtest: invalid metric value in %q: %w", line, err)
+		}
+		m.Values = append(m.Values, v)
+	}
+	switch m.Type {
+	case "c", "g", "ms", "h", "d", "s":
+	default:
+		return Metric{}, fmt.Errorf("statsdtest: unknown metric type %q in %q", m.Type, line)
+	}
+
+	for _, field := range fields[2:] {
+		var err error
+		switch {
+		case strings.HasPrefix(field, "@"):
+			m.SampleRate, err = strconv.ParseFloat(field[1:], 64)
+		case strings.HasPrefix(field, "#"):
+			m.Tags = parseTags(field[1:])
+		case strings.HasPrefix(field, "c:"):
+			m.Container = field[2:]
+		case strings.HasPrefix(field, "T"):
+			m.Timestamp, err = strconv.ParseInt(field[1:], 10, 64)
+		default:
+			err = fmt.Errorf("unknown field %q", field)
+		}
+		if err != nil {
+			return Metric{}, fmt.Errorf("statsdtest: invalid metric %q: %w", line, err)
+		}
+	}
+	return m, nil
+}
+
+// ParseEvent parses a DogStatsD event line
+func ParseEvent(line string) (Event, error) {
+	header := strings.IndexByte(line, ':')
+	if !strings.HasPrefix(line, "_e{") || header < 0 || line[header-1] != '}' {
+		return Event{}, fmt.Errorf("statsdtest: invalid event header in %q", line)
+	}
+	lengths := strings.Split(line[3:header-1], ",")
+	if len(lengths) != 2 {
+		return Event{}, fmt.Errorf("statsdtest: invalid event header in %q", line)
+	}
+	titleLen, err := strconv.Atoi(lengths[0])
+	if err != nil || titleLen < 0 {
+		return Event{}, fmt.Errorf("statsdtest: invalid event title length in %q", line)
+	}
+	textLen, err := strconv.Atoi(lengths[1])
+	if err != nil || textLen < 0 {
+		return Event{}, fmt.Errorf("statsdtest: invalid event text length in %q", line)
+	}
+	body := line[header+1:]
+	if len(body) < titleLen+1+textLen || body[titleLen] != '|' {
+		return Event{}, fmt.Errorf("statsdtest: event shorter than its header in %q", line)
+	}
+	e := Event{
+		Title: body[:titleLen],
+		Text:  strings.ReplaceAll(body[titleLen+1:titleLen+1+textLen], `\n`, "\n"),
+	}
+
+	rest := body[titleLen+1+textLen:]
+	if rest == "" {
+		return e, nil
+	}
+	if rest[0] != '|' {
+		return Event{}, fmt.Errorf("statsdtest: event longer than its header in %q", line)
+	}
+	for _, field := range strings.Split(rest[1:], "|") {
+		switch {
+		case strings.HasPrefix(field, "d:"):
+			e.Timestamp, err = strconv.ParseInt(field[2:], 10, 64)
+		case strings.HasPrefix(field, "h:"):
+			e.Hostname = field[2:]
+		case strings.HasPrefix(field, "k:"):
+			e.AggregationKey = field[2:]
+		case strings.HasPrefix(field, "p:"):
+			e.Priority = field[2:]
+		case strings.HasPrefix(field, "s:"):
+			e.SourceType = field[2:]
+		case strings.HasPrefix(field, "t:"):
+			e.AlertType = field[2:]
+		case strings.HasPrefix(field, "#"):
+			e.Tags = parseTags(field[1:])
+		default:
+			err = fmt.Errorf("unknown field %q", field)
+		}
+		if err != nil {
+			return Event{}, fmt.Errorf("statsdtest: invalid event %q: %w", line, err)
+		}
+	}
+	return e, nil
+}
+
+// ParseServiceCheck parses a DogStatsD service check line
+func ParseServiceCheck(line string) (ServiceCheck, error) {
+	fields := strings.Split(line, "|")
+	if len(fields) < 3 || fields[0] != "_sc" {
+		return ServiceCheck{}, fmt.Errorf("statsdtest: invalid service check %q", line)
+	}
+	status, err := strconv.Atoi(fields[2])
+	if err != nil || status < 0 || status > 3 {
+		return ServiceCheck{}, fmt.Errorf("statsdtest: invalid service check status in %q", line)
+	}
+	c := ServiceCheck{Name: fields[1], Status: status}
+	for i, field := range fields[3:] {
+		switch {
+		case strings.HasPrefix(field, "d:"):
+			c.Timestamp, err = strconv.ParseInt(field[2:], 10, 64)
+		case strings.HasPrefix(field, "h:"):
+			c.Hostname = field[2:]
+		case strings.HasPrefix(field, "#"):
+			c.Tags = parseTags(field[1:])
+		case strings.HasPrefix(field, "m:"):
+			// the message is last and may contain |
+			c.Message = strings.ReplaceAll(strings.Join(fields[3+i:], "|")[2:], `\n`, "\n")
+			return c, nil
+		default:
+			err = fmt.Errorf("unknown field %q", field)
+		}
+		if err != nil {
+			return ServiceCheck{}, fmt.Errorf("statsdtest: invalid service check %q: %w", line, err)
+		}
+	}
+	return c, nil
+}
+
+func parseTags(raw string) []string {
+	if raw == "" {
+		return nil
+	}
+	return strings.Split(raw, ",")
+}
+
+func tagMap(tags []string) map[string]string {
+	m := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		if i := strings.IndexByte(tag, ':'); i >= 0 {
+			m[tag[:i]] = tag[i+1:]
+		} else {
+			m[tag] = ""
+		}
+	}
+	return m
+}
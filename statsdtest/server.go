@@ -0,0 +1,213 @@
+// Package statsdtest provides a local DogStatsD server recording and parsing
+// the datagrams it receives, to test statsd clients end to end
+package statsdtest
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPacketSize is larger than any datagram the DogStatsD clients send
+const maxPacketSize = 65536
+
+// Server is a DogStatsD server listening on UDP or on a Unix datagram socket
+type Server struct {
+	conn net.PacketConn
+	addr string
+	path string
+
+	mu      sync.Mutex
+	changed chan struct{}
+	packets [][]byte
+	metrics []Metric
+	events  []Event
+	checks  []ServiceCheck
+	errs    []error
+	done    chan struct{}
+}
+
+// NewUDPServer starts a server on a random port of the loopback interface
+func NewUDPServer() (*Server, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	return newServer(conn, conn.LocalAddr().String(), ""), nil
+}
+
+// NewUDSServer starts a server on a Unix datagram socket created at path,
+// which is removed by Close
+func NewUDSServer(path string) (*Server, error) {
+	conn, err := net.ListenPacket("unixgram", path)
+	if err != nil {
+		return nil, err
+	}
+	return newServer(conn, "unix://"+path, path), nil
+}
+
+func newServer(conn net.PacketConn, addr, path string) *Server {
+	s := &Server{
+		conn:    conn,
+		addr:    addr,
+		path:    path,
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go s.serve()
+	return s
+}
+
+// Addr returns the address to give to statsd.New, host:port for UDP and
+// unix://path for Unix sockets
+func (s *Server) Addr() string {
+	return s.addr
+}
+
+func (s *Server) serve() {
+	defer close(s.done)
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		packet := append([]byte(nil), buf[:n]...)
+		s.record(packet)
+	}
+}
+
+func (s *Server) record(packet []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packets = append(s.packets, packet)
+	for _, line := range bytes.Split(packet, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		switch l := string(line); {
+		case strings.HasPrefix(l, "_e{"):
+			e, err := ParseEvent(l)
+			s.add(err, func() { s.events = append(s.events, e) })
+		case strings.HasPrefix(l, "_sc|"):
+			c, err := ParseServiceCheck(l)
+			s.add(err, func() { s.checks = append(s.checks, c) })
+		default:
+			m, err := ParseMetric(l)
+			s.add(err, func() { s.metrics = append(s.metrics, m) })
+		}
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) add(err error, keep func()) {
+	if err != nil {
+		s.errs = append(s.errs, err)
+		return
+	}
+	keep()
+}
+
+// Packets returns the raw datagrams received so far
+func (s *Server) Packets() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]byte(nil), s.packets...)
+}
+
+// Metrics returns the metrics received so far, in order
+func (s *Server) Metrics() []Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Metric(nil), s.metrics...)
+}
+
+// MetricsNamed returns the metrics received so far under name, in order.
+// Clients sharding their buffers do not keep the order across metrics
+func (s *Server) MetricsNamed(name string) []Metric {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var metrics []Metric
+	for _, m := range s.metrics {
+		if m.Name == name {
+			metrics = append(metrics, m)
+		}
+	}
+	return metrics
+}
+
+// Events returns the events received so far, in order
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Event(nil), s.events...)
+}
+
+// ServiceChecks returns the service checks received so far, in order
+func (s *Server) ServiceChecks() []ServiceCheck {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ServiceCheck(nil), s.checks...)
+}
+
+// Errors returns the parse errors of the malformed lines received so far
+func (s *Server) Errors() []error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]error(nil), s.errs...)
+}
+
+// WaitForMetrics waits until at least n metrics were received and returns
+// them, ok is false when timeout elapsed first
+func (s *Server) WaitForMetrics(n int, timeout time.Duration) (metrics []Metric, ok bool) {
+	ok = s.wait(func() bool { return len(s.metrics) >= n }, timeout)
+	return s.Metrics(), ok
+}
+
+// Wait waits until cond, called with the received lines, returns true or
+// timeout elapses
+func (s *Server) Wait(cond func(metrics []Metric, events []Event, checks []ServiceCheck) bool, timeout time.Duration) bool {
+	return s.wait(func() bool { return cond(s.metrics, s.events, s.checks) }, timeout)
+}
+
+func (s *Server) wait(cond func() bool, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		met, changed := cond(), s.changed
+		s.mu.Unlock()
+		if met {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// Reset forgets everything received so far
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packets, s.metrics, s.events, s.checks, s.errs = nil, nil, nil, nil, nil
+}
+
+// Close stops the server, removing its socket file
+func (s *Server) Close() error {
+	err := s.conn.Close()
+	<-s.done
+	if s.path != "" {
+		if rmErr := os.Remove(s.path); rmErr != nil && !errors.Is(rmErr, os.ErrNotExist) && err == nil {
+			err = rmErr
+		}
+	}
+	return err
+}
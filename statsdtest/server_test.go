@@ -0,0 +1,144 @@
+package statsdtest
+
+import (
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseMetric(t *testing.T) {
+	tables := []struct {
+		line     string
+		expected Metric
+	}{
+		{"page.views:1|c", Metric{Name: "page.views", Values: []float64{1}, Type: "c", SampleRate: 1}},
+		{"fuel.level:0.5|g|#env:prod,canary", Metric{Name: "fuel.level", Values: []float64{0.5}, Type: "g", SampleRate: 1, Tags: []string{"env:prod", "canary"}}},
+		{"song.length:240|h|@0.5|c:abc|T1656581400", Metric{Name: "song.length", Values: []float64{240}, Type: "h", SampleRate: 0.5, Container: "abc", Timestamp: 1656581400}},
+		{"latency:1:2.5:3|d", Metric{Name: "latency", Values: []float64{1, 2.5, 3}, Type: "d", SampleRate: 1}},
+	}
+	for _, table := range tables {
+		t.Run(table.line, func(t *testing.T) {
+			m, err := ParseMetric(table.line)
+			assert.NoError(t, err)
+			assert.Equal(t, table.expected, m)
+		})
+	}
+
+	for _, line := range []string{"page.views", "page.views|c", "page.views:x|c", "page.views:1|z", "page.views:1|c|?"} {
+		_, err := ParseMetric(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	e, err := ParseEvent(`_e{5,12}:title|line1\nline2|d:1656581400|h:host|k:key|p:low|s:go|t:warning|#env:prod`)
+	assert.NoError(t, err)
+	assert.Equal(t, Event{
+		Title:          "title",
+		Text:           "line1\nline2",
+		Timestamp:      1656581400,
+		Hostname:       "host",
+		AggregationKey: "key",
+		Priority:       "low",
+		SourceType:     "go",
+		AlertType:      "warning",
+		Tags:           []string{"env:prod"},
+	}, e)
+
+	for _, line := range []string{"_e{5,4}:title", "_e{a,4}:title|text", "_e{5,4}:title|textx", "_e{5,4}:title|text|?", "_e{-1,0}:x", "_e{1,-2}:x|"} {
+		_, err := ParseEvent(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestParseServiceCheck(t *testing.T) {
+	c, err := ParseServiceCheck(`_sc|db.up|2|d:1656581400|h:host|#env:prod|m:down | since\nnow`)
+	assert.NoError(t, err)
+	assert.Equal(t, ServiceCheck{
+		Name:      "db.up",
+		Status:    2,
+		Timestamp: 1656581400,
+		Hostname:  "host",
+		Tags:      []string{"env:prod"},
+		Message:   "down | since\nnow",
+	}, c)
+
+	for _, line := range []string{"_sc|db.up", "_sc|db.up|4", "_sc|db.up|0|?"} {
+		_, err := ParseServiceCheck(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func testServer(t *testing.T, s *Server) {
+	t.Helper()
+	client, err := statsd.New(s.Addr(), statsd.WithNamespace("game."), statsd.WithTags([]string{"serverType:room"}), statsd.WithoutTelemetry())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+
+	assert.NoError(t, client.Count("joins", 2, []string{"route:room.join"}, 1))
+	assert.NoError(t, client.Gauge("players", 12, nil, 1))
+	assert.NoError(t, client.Event(&statsd.Event{Title: "deploy", Text: "v2", Tags: []string{"team:core"}}))
+	assert.NoError(t, client.ServiceCheck(&statsd.ServiceCheck{Name: "room.up", Status: statsd.Warn, Message: "slow"}))
+	assert.NoError(t, client.Flush())
+
+	assert.True(t, s.Wait(func(metrics []Metric, events []Event, checks []ServiceCheck) bool {
+		return len(metrics) == 2 && len(events) == 1 && len(checks) == 1
+	}, time.Second))
+	assert.NotEmpty(t, s.Packets())
+	assert.Empty(t, s.Errors())
+
+	if joins := s.MetricsNamed("game.joins"); assert.Len(t, joins, 1) {
+		assert.Equal(t, "c", joins[0].Type)
+		assert.Equal(t, float64(2), joins[0].Value())
+		assert.Equal(t, map[string]string{"serverType": "room", "route": "room.join"}, joins[0].TagMap())
+	}
+	if players := s.MetricsNamed("game.players"); assert.Len(t, players, 1) {
+		assert.Equal(t, "g", players[0].Type)
+		assert.Equal(t, float64(12), players[0].Value())
+	}
+	if events := s.Events(); assert.Len(t, events, 1) {
+		assert.Equal(t, "deploy", events[0].Title)
+		assert.Contains(t, events[0].Tags, "team:core")
+	}
+	if checks := s.ServiceChecks(); assert.Len(t, checks, 1) {
+		assert.Equal(t, "room.up", checks[0].Name)
+		assert.Equal(t, int(statsd.Warn), checks[0].Status)
+		assert.Equal(t, "slow", checks[0].Message)
+	}
+
+	s.Reset()
+	assert.Empty(t, s.Metrics())
+}
+
+func TestUDPServer(t *testing.T) {
+	s, err := NewUDPServer()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	testServer(t, s)
+}
+
+func TestUDSServer(t *testing.T) {
+	s, err := NewUDSServer(filepath.Join(t.TempDir(), "dsd.socket"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	testServer(t, s)
+}
+
+func TestServerWaitForMetricsTimeout(t *testing.T) {
+	s, err := NewUDPServer()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+	metrics, ok := s.WaitForMetrics(1, 10*time.Millisecond)
+	assert.False(t, ok)
+	assert.Empty(t, metrics)
+}
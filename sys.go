@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"context"
+	"math"
+	"runtime"
+	rtmetrics "runtime/metrics"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sysQuantiles are the quantiles reported for runtime distributions
+var sysQuantiles = []float64{0.5, 0.9, 0.99, 1}
+
+// sysGauges maps runtime/metrics names to the gauges reported for them
+var sysGauges = map[string]string{
+	"/sched/goroutines:goroutines":       Goroutines,
+	"/memory/classes/heap/objects:bytes": HeapSize,
+	"/gc/heap/objects:objects":           HeapObjects,
+	"/gc/heap/goal:bytes":                HeapGoal,
+	"/memory/classes/heap/stacks:bytes":  StackMemory,
+	"/memory/classes/total:bytes":        OSMemory,
+}
+
+// sysCounters maps monotonic runtime/metrics names to the counters reported
+// for them
+var sysCounters = map[string]string{
+	"/gc/cycles/total:gc-cycles": GCCycles,
+}
+
+// sysDistributions maps runtime/metrics histograms to the gauges reported,
+// one per quantile, for them. The runtime histograms are cumulative since the
+// process start, the quantiles are computed over the observations made since
+// the previous Collect
+var sysDistributions = map[string]string{
+	"/gc/pauses:seconds":       GCPauses,
+	"/sched/latencies:seconds": SchedLatencies,
+}
+
+// SysMetricsCollector reads the runtime statistics once per Collect and
+// reports them to every reporter
+type SysMetricsCollector struct {
+	reporters []Reporter
+	samples   []rtmetrics.Sample
+
+	mu       sync.Mutex
+	counters map[string]uint64
+	counts   map[string][]uint64
+}
+
+// NewSysMetricsCollector returns a collector for the runtime/metrics
+// supported by the running Go version
+func NewSysMetricsCollector(reporters []Reporter) *SysMetricsCollector {
+	supported := make(map[string]bool)
+	for _, d := range rtmetrics.All() {
+		supported[d.Name] = true
+	}
+	c := &SysMetricsCollector{
+		reporters: reporters,
+		counters:  make(map[string]uint64),
+		counts:    make(map[string][]uint64),
+	}
+	for _, names := range []map[string]string{sysGauges, sysCounters, sysDistributions} {
+		for name := range names {
+			if supported[name] {
+				c.samples = append(c.samples, rtmetrics.Sample{Name: name})
+			}
+		}
+	}
+	return c
+}
+
+// Collect reads the runtime statistics and reports them. Counters are
+// reported as the increase since the previous Collect
+func (c *SysMetricsCollector) Collect() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rtmetrics.Read(c.samples)
+
+	gauges := map[string]float64{
+		GoMaxProcs: float64(runtime.GOMAXPROCS(0)),
+	}
+	counters := map[string]uint64{
+		CgoCalls: uint64(runtime.NumCgoCall()),
+	}
+	distributions := make(map[string]*rtmetrics.Float64Histogram)
+	for _, sample := range c.samples {
+		switch sample.Value.Kind() {
+		case rtmetrics.KindUint64:
+			if metric, ok := sysCounters[sample.Name]; ok {
+				counters[metric] = sample.Value.Uint64()
+				continue
+			}
+			gauges[sysGauges[sample.Name]] = float64(sample.Value.Uint64())
+		case rtmetrics.KindFloat64:
+			gauges[sysGauges[sample.Name]] = sample.Value.Float64()
+		case rtmetrics.KindFloat64Histogram:
+			metric := sysDistributions[sample.Name]
+			distributions[metric] = c.delta(metric, sample.Value.Float64Histogram())
+		}
+	}
+	increases := make(map[string]float64, len(counters))
+	for metric, value := range counters {
+		increases[metric] = float64(value - c.counters[metric])
+		c.counters[metric] = value
+	}
+
+	for _, r := range c.reporters {
+		for metric, value := range gauges {
+			r.ReportGauge(metric, map[string]string{}, value)
+		}
+		for metric, increase := range increases {
+			r.ReportCount(metric, map[string]string{}, increase)
+		}
+		for metric, hist := range distributions {
+			for _, q := range sysQuantiles {
+				r.ReportGauge(metric, map[string]string{
+					"quantile": strconv.FormatFloat(q, 'f', -1, 64),
+				}, histogramQuantile(hist, q))
+			}
+		}
+	}
+}
+
+// delta returns the observations hist gained since the previous Collect
+func (c *SysMetricsCollector) delta(metric string, hist *rtmetrics.Float64Histogram) *rtmetrics.Float64Histogram {
+	prev := c.counts[metric]
+	counts := make([]uint64, len(hist.Counts))
+	for i, count := range hist.Counts {
+		counts[i] = count
+		if len(prev) == len(counts) {
+			counts[i] -= prev[i]
+		}
+	}
+	c.counts[metric] = append(prev[:0], hist.Counts...)
+	return &rtmetrics.Float64Histogram{Counts: counts, Buckets: hist.Buckets}
+}
+
+// Run collects every period until ctx is done
+func (c *SysMetricsCollector) Run(ctx context.Context, period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		c.Collect()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// histogramQuantile returns the upper bound of the bucket holding the q
+// quantile, falling back to the lower bound for the +Inf bucket
+func histogramQuantile(hist *rtmetrics.Float64Histogram, q float64) float64 {
+	var total uint64
+	for _, count := range hist.Counts {
+		total += count
+	}
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint64(math.Ceil(q * float64(total)))
+	if rank == 0 {
+		rank = 1
+	}
+	var seen uint64
+	for i, count := range hist.Counts {
+		seen += count
+		if seen < rank {
+			continue
+		}
+		if upper := hist.Buckets[i+1]; !math.IsInf(upper, 1) {
+			return upper
+		}
+		return hist.Buckets[i]
+	}
+	return hist.Buckets[len(hist.Buckets)-1]
+}
@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"github.com/golang/mock/gomock"
+	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"runtime"
+	rtmetrics "runtime/metrics"
+	"testing"
+)
+
+func TestHistogramQuantile(t *testing.T) {
+	hist := &rtmetrics.Float64Histogram{
+		Counts:  []uint64{5, 3, 0, 2},
+		Buckets: []float64{math.Inf(-1), 1, 2, 4, math.Inf(1)},
+	}
+	tables := []struct {
+		q        float64
+		expected float64
+	}{
+		{0, 1},
+		{0.5, 1},
+		{0.8, 2},
+		{0.9, 4},
+		{1, 4},
+	}
+	for _, table := range tables {
+		assert.Equal(t, table.expected, histogramQuantile(hist, table.q), "quantile %v", table.q)
+	}
+	assert.Equal(t, float64(0), histogramQuantile(&rtmetrics.Float64Histogram{
+		Counts:  []uint64{0},
+		Buckets: []float64{0, 1},
+	}, 0.5))
+}
+
+func TestSysMetricsCollectorCollect(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockMetricsReporter := mocks.NewMockReporter(ctrl)
+
+	reported := map[string]int{}
+	mockMetricsReporter.EXPECT().ReportGauge(gomock.Any(), gomock.Any(), gomock.Any()).Do(
+		func(metric string, tags map[string]string, value float64) {
+			reported[metric]++
+		},
+	).AnyTimes()
+	increases := map[string]float64{}
+	mockMetricsReporter.EXPECT().ReportCount(gomock.Any(), gomock.Any(), gomock.Any()).Do(
+		func(metric string, tags map[string]string, count float64) {
+			increases[metric] += count
+		},
+	).AnyTimes()
+
+	collector := NewSysMetricsCollector([]Reporter{mockMetricsReporter})
+	collector.Collect()
+
+	for _, metric := range []string{Goroutines, HeapSize, HeapObjects, HeapGoal, StackMemory, OSMemory, GoMaxProcs} {
+		assert.Equal(t, 1, reported[metric], metric)
+	}
+	for _, metric := range []string{GCPauses, SchedLatencies} {
+		assert.Equal(t, len(sysQuantiles), reported[metric], metric)
+	}
+	cycles := increases[GCCycles]
+	assert.Contains(t, increases, CgoCalls)
+
+	runtime.GC()
+	collector.Collect()
+	assert.Greater(t, increases[GCCycles], cycles)
+}
@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+)
+
+// Unit is the unit of the values reported for a metric
+type Unit int
+
+const (
+	// UnitNone marks values reporters forward unchanged
+	UnitNone Unit = iota
+	UnitNanoseconds
+	UnitMicroseconds
+	UnitMilliseconds
+	UnitSeconds
+	UnitBytes
+)
+
+// secondsPer holds the length of each time unit in seconds
+var secondsPer = map[Unit]float64{
+	UnitNanoseconds:  1e-9,
+	UnitMicroseconds: 1e-6,
+	UnitMilliseconds: 1e-3,
+	UnitSeconds:      1,
+}
+
+var (
+	metricUnits = map[string]Unit{
+		ResponseTime:          UnitNanoseconds,
+		ProcessDelay:          UnitNanoseconds,
+		GCPauses:              UnitSeconds,
+		SchedLatencies:        UnitSeconds,
+		HeapSize:              UnitBytes,
+		HeapGoal:              UnitBytes,
+		StackMemory:           UnitBytes,
+		OSMemory:              UnitBytes,
+		ProcessCPUSeconds:     UnitSeconds,
+		ProcessResidentMemory: UnitBytes,
+		ProcessVirtualMemory:  UnitBytes,
+	}
+	metricUnitsMutex sync.RWMutex
+)
+
+// RegisterMetricUnit declares the unit custom metrics are reported in, so
+// reporters can convert them to their backend unit
+func RegisterMetricUnit(metric string, unit Unit) {
+	metricUnitsMutex.Lock()
+	defer metricUnitsMutex.Unlock()
+	metricUnits[metric] = unit
+}
+
+// MetricUnit returns the unit metric is reported in, UnitNone when unknown
+func MetricUnit(metric string) Unit {
+	metricUnitsMutex.RLock()
+	defer metricUnitsMutex.RUnlock()
+	return metricUnits[metric]
+}
+
+// convertUnit converts value between two time units, any other pair of units
+// leaves it unchanged
+func convertUnit(value float64, from, to Unit) float64 {
+	fromSeconds, ok := secondsPer[from]
+	if !ok {
+		return value
+	}
+	toSeconds, ok := secondsPer[to]
+	if !ok {
+		return value
+	}
+	return value * fromSeconds / toSeconds
+}
+
+// secondsNames maps the built-in nanoseconds metrics to their seconds names
+var secondsNames = map[string]string{
+	ResponseTime:          "response_time_seconds",
+	ResponseTimeHistogram: "response_time_seconds_histogram",
+	ProcessDelay:          "handler_delay_seconds",
+}
+
+// secondsName returns the seconds name of a time metric: the mapped one for
+// the built-in metrics, the _ns suffix replaced by _seconds for the others
+func secondsName(name string) string {
+	if seconds, ok := secondsNames[name]; ok {
+		return seconds
+	}
+	if strings.HasSuffix(name, "_ns") {
+		return strings.TrimSuffix(name, "_ns") + "_seconds"
+	}
+	return name
+}
@@ -0,0 +1,89 @@
+package metrics
+
+import (
+	"github.com/golang/mock/gomock"
+	config "github.com/gotechbook/gotechbook-framework-config"
+	"github.com/gotechbook/gotechbook-framework-metrics/mocks"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConvertUnit(t *testing.T) {
+	assert.Equal(t, float64(1.5), convertUnit(1.5e6, UnitNanoseconds, UnitMilliseconds))
+	assert.Equal(t, float64(0.25), convertUnit(250, UnitMilliseconds, UnitSeconds))
+	assert.Equal(t, float64(2000), convertUnit(2, UnitSeconds, UnitMilliseconds))
+	assert.Equal(t, float64(42), convertUnit(42, UnitBytes, UnitMilliseconds))
+	assert.Equal(t, float64(42), convertUnit(42, UnitNone, UnitSeconds))
+}
+
+func TestSecondsName(t *testing.T) {
+	assert.Equal(t, "response_time_seconds", secondsName(ResponseTime))
+	assert.Equal(t, "response_time_seconds_histogram", secondsName(ResponseTimeHistogram))
+	assert.Equal(t, "handler_delay_seconds", secondsName(ProcessDelay))
+	assert.Equal(t, "dns_lookup_seconds", secondsName("dns_lookup_ns"))
+	assert.Equal(t, "ns_lookups", secondsName("ns_lookups"))
+}
+
+func TestStatsdReporterSummaryUnits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockClient := mocks.NewMockClient(ctrl)
+	metrics := config.Metrics{GoTechBookFrameworkMetricsStatsdRate: 1}
+
+	sr, err := NewStatsdReporter(metrics, "game", mockClient)
+	assert.NoError(t, err)
+	legacy, err := NewStatsdReporterWithOptions(metrics, "game", WithStatsdClient(mockClient), WithStatsdLegacyUnits())
+	assert.NoError(t, err)
+
+	mockClient.EXPECT().TimeInMilliseconds(ResponseTime, float64(12.5), gomock.Any(), float64(1))
+	mockClient.EXPECT().TimeInMilliseconds(ResponseTime, float64(12.5e6), gomock.Any(), float64(1))
+	mockClient.EXPECT().TimeInMilliseconds("custom", float64(7), gomock.Any(), float64(1))
+
+	assert.NoError(t, sr.ReportSummary(ResponseTime, nil, 12.5e6))
+	assert.NoError(t, legacy.ReportSummary(ResponseTime, nil, 12.5e6))
+	assert.NoError(t, sr.ReportSummary("custom", nil, 7))
+
+	mockClient.EXPECT().Histogram(ResponseTime, float64(12.5), gomock.Any(), float64(1))
+	mockClient.EXPECT().Histogram(ResponseTime, float64(12.5e6), gomock.Any(), float64(1))
+	assert.NoError(t, sr.ReportHistogram(ResponseTime, nil, 12.5e6))
+	assert.NoError(t, legacy.ReportHistogram(ResponseTime, nil, 12.5e6))
+
+	timer, err := sr.Timer("custom")
+	assert.NoError(t, err)
+	mockClient.EXPECT().TimeInMilliseconds("custom", float64(3), gomock.Any(), float64(1))
+	timer.With().Observe(3 * time.Millisecond)
+}
+
+func TestPrometheusReporterUnits(t *testing.T) {
+	labels := map[string]string{"route": "room.join", "status": "ok", "type": "handler", "code": ""}
+
+	p, registry := newTestPrometheusReporter(t, nil)
+	assert.NoError(t, p.ReportSummary(ResponseTime, labels, 2e9))
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP gotechbook_handler_response_time_seconds the time to process a msg in seconds
+# TYPE gotechbook_handler_response_time_seconds summary
+gotechbook_handler_response_time_seconds{code="",game="",route="room.join",serverType="game",status="ok",type="handler",quantile="0.7"} 2
+gotechbook_handler_response_time_seconds{code="",game="",route="room.join",serverType="game",status="ok",type="handler",quantile="0.95"} 2
+gotechbook_handler_response_time_seconds{code="",game="",route="room.join",serverType="game",status="ok",type="handler",quantile="0.99"} 2
+gotechbook_handler_response_time_seconds_sum{code="",game="",route="room.join",serverType="game",status="ok",type="handler"} 2
+gotechbook_handler_response_time_seconds_count{code="",game="",route="room.join",serverType="game",status="ok",type="handler"} 1
+`), "gotechbook_handler_response_time_seconds"))
+
+	legacyRegistry := prometheus.NewRegistry()
+	legacy, err := NewPrometheusReporter("game", config.Metrics{}, nil, WithRegistry(legacyRegistry), WithoutServer(), WithPrometheusLegacyUnits())
+	assert.NoError(t, err)
+	assert.NoError(t, legacy.ReportSummary(ResponseTime, labels, 2e9))
+	assert.NoError(t, testutil.GatherAndCompare(legacyRegistry, strings.NewReader(`
+# HELP gotechbook_handler_response_time_ns the time to process a msg in nanoseconds
+# TYPE gotechbook_handler_response_time_ns summary
+gotechbook_handler_response_time_ns{code="",game="",route="room.join",serverType="game",status="ok",type="handler",quantile="0.7"} 2e+09
+gotechbook_handler_response_time_ns{code="",game="",route="room.join",serverType="game",status="ok",type="handler",quantile="0.95"} 2e+09
+gotechbook_handler_response_time_ns{code="",game="",route="room.join",serverType="game",status="ok",type="handler",quantile="0.99"} 2e+09
+gotechbook_handler_response_time_ns_sum{code="",game="",route="room.join",serverType="game",status="ok",type="handler"} 2e+09
+gotechbook_handler_response_time_ns_count{code="",game="",route="room.join",serverType="game",status="ok",type="handler"} 1
+`), "gotechbook_handler_response_time_ns"))
+}